@@ -0,0 +1,166 @@
+package fare
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultCheckpointInterval is the interval of writing the checkpoint
+const defaultCheckpointInterval = 10 * time.Second
+
+// Checkpoint is the progress of a run up to the last ride which is written to the outputs,
+// a run is resumed from it by truncating the outputs to their offsets and setting it as Config.Resume
+type Checkpoint struct {
+	// InputOffset is the byte offset of the input after the last ride
+	InputOffset int64 `json:"input_offset"`
+	// OutputOffset, QualityOffset and DeadLetterOffset are the sizes of the output, the quality report
+	// and the dead letters up to the last ride
+	OutputOffset     int64 `json:"output_offset"`
+	QualityOffset    int64 `json:"quality_offset"`
+	DeadLetterOffset int64 `json:"dead_letter_offset"`
+}
+
+// ReadCheckpoint reads the checkpoint file
+func ReadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+// write writes the checkpoint file atomically, so an interrupted write keeps the previous checkpoint
+func (c Checkpoint) write(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// progress is emitted after the fares of each ride in the order of the input
+type progress struct {
+	// offset is the byte offset of the input after the ride
+	offset int64
+}
+
+// countingWriter counts the bytes written to the writer
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.count += int64(n)
+	return n, err
+}
+
+// sync commits the written data to stable storage if the writer is a file
+func (w *countingWriter) sync() error {
+	if file, ok := w.writer.(interface{ Sync() error }); ok {
+		return file.Sync()
+	}
+	return nil
+}
+
+// checkpointer writes the checkpoint of the written rides every interval
+type checkpointer struct {
+	path     string
+	interval time.Duration
+	outputs  *outputs
+	// checkpoint is the last written checkpoint and offset is the input offset of the last written ride
+	checkpoint Checkpoint
+	offset     int64
+	saved      time.Time
+	// written tells there is a checkpoint
+	written bool
+}
+
+// newCheckpointer creates a checkpointer which starts from the checkpoint of the run which is resumed
+func newCheckpointer(conf *Config, out *outputs) *checkpointer {
+	interval := conf.CheckpointInterval
+	if interval == 0 {
+		interval = defaultCheckpointInterval
+	}
+	c := &checkpointer{
+		path:     conf.Checkpoint,
+		interval: interval,
+		outputs:  out,
+		saved:    time.Now(),
+	}
+	if conf.Resume != nil {
+		c.checkpoint = *conf.Resume
+		c.offset = conf.Resume.InputOffset
+		c.written = true
+	}
+	return c
+}
+
+// progress records that the rides up to the input offset are written to the sinks,
+// and writes the checkpoint if the interval has passed
+func (c *checkpointer) progress(p progress) error {
+	c.offset = p.offset
+	if time.Since(c.saved) < c.interval || c.written && c.offset == c.checkpoint.InputOffset {
+		return nil
+	}
+	return c.save()
+}
+
+// save flushes the sinks and writes the checkpoint
+func (c *checkpointer) save() error {
+	c.saved = time.Now()
+
+	if err := c.outputs.flush(); err != nil {
+		return err
+	}
+	checkpoint := Checkpoint{InputOffset: c.offset}
+	for _, output := range []struct {
+		writer *countingWriter
+		offset *int64
+	}{
+		{c.outputs.output, &checkpoint.OutputOffset},
+		{c.outputs.quality, &checkpoint.QualityOffset},
+		{c.outputs.deadLetter, &checkpoint.DeadLetterOffset},
+	} {
+		if output.writer == nil {
+			continue
+		}
+		*output.offset = output.writer.count
+		if err := output.writer.sync(); err != nil {
+			return err
+		}
+	}
+
+	if err := checkpoint.write(c.path); err != nil {
+		return err
+	}
+	c.checkpoint = checkpoint
+	c.written = true
+	return nil
+}
@@ -0,0 +1,133 @@
+package fare
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpoint_write(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fare-checkpoint")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+
+	want := Checkpoint{InputOffset: 10, OutputOffset: 20, QualityOffset: 30}
+	assert.Nil(t, want.write(path))
+	got, err := ReadCheckpoint(path)
+	assert.Nil(t, err)
+	assert.Equal(t, want, *got)
+
+	// no temporary files are left
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestEstimator_Run_checkpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fare-checkpoint")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+
+	data := `id,lat,lng,timestamp
+1,37.966660,23.728308,1405594957
+1,37.966627,23.728263,1405594966
+2,37.966660,23.728308,1405594957
+2,37.966627,23.728263,1405594966
+3,37.966660,23.728308,1405594957
+3,37.966627,23.728263,1405594966
+`
+	afterFirstRide := int64(strings.Index(data, "2,"))
+
+	tests := []struct {
+		name       string
+		resume     *Checkpoint
+		existing   string
+		output     string
+		checkpoint Checkpoint
+	}{
+		{
+			name:       "whole run",
+			output:     "1,3.47\n2,3.47\n3,3.47\n",
+			checkpoint: Checkpoint{InputOffset: int64(len(data)), OutputOffset: 21, QualityOffset: 33},
+		},
+		{
+			name:       "resumed run",
+			resume:     &Checkpoint{InputOffset: afterFirstRide, OutputOffset: 7, QualityOffset: 11},
+			existing:   "1,3.47\n",
+			output:     "1,3.47\n2,3.47\n3,3.47\n",
+			checkpoint: Checkpoint{InputOffset: int64(len(data)), OutputOffset: 21, QualityOffset: 33},
+		},
+		{
+			name:       "resumed after the end",
+			resume:     &Checkpoint{InputOffset: int64(len(data)), OutputOffset: 21, QualityOffset: 33},
+			existing:   "1,3.47\n2,3.47\n3,3.47\n",
+			output:     "1,3.47\n2,3.47\n3,3.47\n",
+			checkpoint: Checkpoint{InputOffset: int64(len(data)), OutputOffset: 21, QualityOffset: 33},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.Remove(path)
+			out := bytes.NewBufferString(test.existing)
+			report := &bytes.Buffer{}
+			options := &Config{
+				MaxSpeed:    100,
+				Concurrency: 2,
+				Ordered:     true,
+				Schema:      Schema{Header: true},
+				Checkpoint:  path,
+				Resume:      test.resume,
+			}
+
+			estimator, err := NewEstimator(strings.NewReader(data), out, options)
+			assert.Nil(t, err)
+			err = estimator.WithQualityReport(report).Run(context.TODO())
+			assert.Nil(t, err)
+
+			assert.Equal(t, test.output, out.String())
+			checkpoint, err := ReadCheckpoint(path)
+			assert.Nil(t, err)
+			assert.Equal(t, test.checkpoint, *checkpoint)
+		})
+	}
+}
+
+func TestEstimator_Run_checkpointInput(t *testing.T) {
+	options := &Config{MaxSpeed: 100, Concurrency: 1, Ordered: true, Checkpoint: "checkpoint.json"}
+
+	estimator, err := NewEstimator(bytes.NewBufferString("1,37.966660,23.728308,1405594957\n"), ioutil.Discard, options)
+	assert.Nil(t, err)
+	err = estimator.Run(context.TODO())
+	assert.EqualError(t, err, "checkpoint: the input should be a single uncompressed file")
+}
+
+func TestCheckpointer_progress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fare-checkpoint")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+
+	out := &bytes.Buffer{}
+	output := &countingWriter{writer: out}
+	sinks := []sink{newSink(output, FormatCSV, nil, nil)}
+	c := newCheckpointer(&Config{Checkpoint: path, CheckpointInterval: time.Nanosecond}, &outputs{sinks: sinks, output: output})
+
+	assert.Nil(t, sinks[0].write(rideFare{rideId: 1, fare: 3.47}))
+	time.Sleep(time.Millisecond)
+	assert.Nil(t, c.progress(progress{offset: 40}))
+
+	// the sinks are flushed before the checkpoint is written
+	assert.Equal(t, "1,3.47\n", out.String())
+	checkpoint, err := ReadCheckpoint(path)
+	assert.Nil(t, err)
+	assert.Equal(t, Checkpoint{InputOffset: 40, OutputOffset: 7}, *checkpoint)
+}
@@ -0,0 +1,319 @@
+package fare
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/cubny/fare/internal/pipeline"
+)
+
+// errParallelChunks is returned when ParallelChunks is used with an option which needs the rides in order
+var errParallelChunks = errors.New("ParallelChunks only supports the CSV input and can not be Ordered or Unsorted")
+
+// countLinesBufferSize is the size of the blocks which are read to count the lines of a chunk
+const countLinesBufferSize = 64 * 1024
+
+// chunk is a byte range of the input which starts at the first line of a ride
+type chunk struct {
+	start, end int64
+	// lines is the number of lines before the chunk
+	lines int
+}
+
+// chunkedInput is an input which is split into chunks, each one is parsed by its own source
+type chunkedInput struct {
+	input  io.ReaderAt
+	chunks []chunk
+	// header is the header of the input which is prepended to the chunks after the first one
+	header []byte
+	// headerLines is the number of lines of the header
+	headerLines int
+}
+
+// sizeOf returns the size of the input if it can be read at any offset, like files
+func sizeOf(r io.Reader) (io.ReaderAt, int64, bool) {
+	input, ok := r.(io.ReaderAt)
+	if !ok {
+		return nil, 0, false
+	}
+	switch r := r.(type) {
+	case interface{ Size() int64 }:
+		return input, r.Size(), true
+	case interface{ Stat() (os.FileInfo, error) }:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return nil, 0, false
+		}
+		return input, info.Size(), true
+	}
+	return nil, 0, false
+}
+
+// chunkInput splits the input into ParallelChunks chunks, it returns nil if the input is read as a whole,
+// which is when ParallelChunks is not more than 1, there are several inputs, or the input is compressed
+// or can not be read at any offset, e.g. stdin.
+// With a Checkpoint or Resume, the input is a single chunk from the offset to resume from, and the input
+// must be a single uncompressed file
+func (e *estimator) chunkInput() (*chunkedInput, error) {
+	resumable := e.conf.Checkpoint != "" || e.conf.Resume != nil
+	if e.conf.ParallelChunks <= 1 && !resumable {
+		return nil, nil
+	}
+	if len(e.readers) != 1 {
+		return e.notChunked(resumable)
+	}
+	input, size, ok := sizeOf(e.readers[0])
+	if !ok {
+		return e.notChunked(resumable)
+	}
+	if size == 0 {
+		return &chunkedInput{input: input}, nil
+	}
+	magic := make([]byte, len(gzipMagic))
+	if _, err := input.ReadAt(magic, 0); err == nil && bytes.Equal(magic, gzipMagic) {
+		return e.notChunked(resumable)
+	}
+
+	column, headerEnd := 0, int64(0)
+	if !e.conf.FastCSV {
+		reader := newCSVReader(io.NewSectionReader(input, 0, size), e.conf.Schema)
+		if err := reader.resolveColumns(); err != nil {
+			return nil, err
+		}
+		column = reader.columns[0]
+		if e.conf.Schema.Header {
+			headerEnd = reader.reader.InputOffset()
+		}
+	}
+
+	chunked := &chunkedInput{input: input}
+	if headerEnd > 0 {
+		chunked.header = make([]byte, headerEnd)
+		if _, err := input.ReadAt(chunked.header, 0); err != nil {
+			return nil, err
+		}
+		chunked.headerLines = bytes.Count(chunked.header, []byte{'\n'})
+	}
+
+	if resumable {
+		return chunked, chunked.resumeAt(e.conf.Resume, size)
+	}
+
+	comma := []byte{','}
+	if e.conf.Schema.Comma != 0 {
+		comma = []byte(string(e.conf.Schema.Comma))
+	}
+	comment := []byte(string(e.conf.Schema.Comment))
+	rideID := func(line []byte) ([]byte, bool) {
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 || e.conf.Schema.Comment != 0 && bytes.HasPrefix(line, comment) {
+			return nil, false
+		}
+		fields := bytes.Split(line, comma)
+		if len(fields) <= column {
+			return nil, false
+		}
+		return fields[column], true
+	}
+
+	start := int64(0)
+	for i := 1; i <= e.conf.ParallelChunks; i++ {
+		end := size
+		if i < e.conf.ParallelChunks {
+			aligned, err := alignChunk(input, size, headerEnd+(size-headerEnd)*int64(i)/int64(e.conf.ParallelChunks), rideID)
+			if err != nil {
+				return nil, err
+			}
+			end = aligned
+		}
+		if end > start {
+			chunked.chunks = append(chunked.chunks, chunk{start: start, end: end})
+			start = end
+		}
+	}
+
+	if err := chunked.countLines(); err != nil {
+		return nil, err
+	}
+	return chunked, nil
+}
+
+// notChunked tells that the input can not be chunked, which fails when it is resumable
+func (e *estimator) notChunked(resumable bool) (*chunkedInput, error) {
+	if resumable {
+		return nil, errors.New("checkpoint: the input should be a single uncompressed file")
+	}
+	return nil, nil
+}
+
+// resumeAt makes the input a single chunk from the input offset of the checkpoint, or from the start
+// of the input if there is no checkpoint
+func (c *chunkedInput) resumeAt(checkpoint *Checkpoint, size int64) error {
+	start := int64(0)
+	if checkpoint != nil {
+		start = checkpoint.InputOffset
+	}
+	if start < 0 || start > size {
+		return fmt.Errorf("checkpoint: input offset %d is out of the input of %d bytes", start, size)
+	}
+
+	lines, err := countLines(io.NewSectionReader(c.input, 0, start))
+	if err != nil {
+		return err
+	}
+	c.chunks = []chunk{{start: start, end: size, lines: lines}}
+	return nil
+}
+
+// alignChunk returns the offset of the first line of the next ride after the line at the given offset,
+// or the size of the input if there is none. Lines without a ride id, like empty lines, belong to the ride before them
+func alignChunk(input io.ReaderAt, size, offset int64, rideID func(line []byte) ([]byte, bool)) (int64, error) {
+	if offset <= 0 {
+		offset = 1
+	}
+	// the line at the offset starts after the line break before it
+	reader := bufio.NewReader(io.NewSectionReader(input, offset-1, size-offset+1))
+	position := offset - 1
+	skip, err := reader.ReadBytes('\n')
+	position += int64(len(skip))
+	if err == io.EOF {
+		return size, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var first []byte
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
+			return size, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if id, ok := rideID(line); ok {
+			if first == nil {
+				first = id
+			} else if !bytes.Equal(id, first) {
+				return position, nil
+			}
+		}
+		position += int64(len(line))
+	}
+}
+
+// countLines counts the lines before each chunk, the lines of the chunks are counted concurrently
+func (c *chunkedInput) countLines() error {
+	counts := make([]int, len(c.chunks))
+	errs := make([]error, len(c.chunks))
+	var wg sync.WaitGroup
+	wg.Add(len(c.chunks))
+	for i := range c.chunks {
+		go func(i int) {
+			defer wg.Done()
+			counts[i], errs[i] = countLines(io.NewSectionReader(c.input, c.chunks[i].start, c.chunks[i].end-c.chunks[i].start))
+		}(i)
+	}
+	wg.Wait()
+
+	lines := 0
+	for i := range c.chunks {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		c.chunks[i].lines = lines
+		lines += counts[i]
+	}
+	return nil
+}
+
+// countLines counts the line breaks of the reader
+func countLines(r io.Reader) (int, error) {
+	buf := make([]byte, countLinesBufferSize)
+	lines := 0
+	for {
+		n, err := r.Read(buf)
+		lines += bytes.Count(buf[:n], []byte{'\n'})
+		switch {
+		case err == io.EOF:
+			return lines, nil
+		case err != nil:
+			return 0, err
+		}
+	}
+}
+
+// chunkSource is the source of a chunk, it numbers the lines by their line number in the whole input
+// and sets their offsets to the offsets in the whole input
+type chunkSource struct {
+	source
+	// lines is added to the line numbers of the source
+	lines int
+	// offset is added to the input offsets of the source
+	offset int64
+}
+
+func (c *chunkSource) read() (record, error) {
+	rec, err := c.source.read()
+	if rec.number > 0 {
+		rec.number += c.lines
+	}
+	rec.offset += c.offset
+	return rec, err
+}
+
+// parseChunks parses and groups the chunks of the input concurrently into a single channel of rides,
+// as the chunks are aligned to rides, no ride is split. The rides are in no particular order.
+// The last error channel has no error, it is closed when the rides of all chunks are forwarded
+func (e *estimator) parseChunks(ctx context.Context, chunked *chunkedInput) (<-chan []record, []<-chan error) {
+	outc := make(chan []record)
+	done := make(chan error)
+	var errcs []<-chan error
+	var wg sync.WaitGroup
+
+	for _, c := range chunked.chunks {
+		var r io.Reader = io.NewSectionReader(chunked.input, c.start, c.end-c.start)
+		lines, offset := c.lines, c.start
+		if c.start > 0 && chunked.header != nil {
+			r = io.MultiReader(bytes.NewReader(chunked.header), r)
+			lines -= chunked.headerLines
+			offset -= int64(len(chunked.header))
+		}
+		var in source = newCSVReader(r, e.conf.Schema)
+		if e.conf.FastCSV {
+			in = newFastCSVReader(r, e.conf.TimestampFormat)
+		}
+
+		read := e.streamFromSource(&chunkSource{source: in, lines: lines, offset: offset})
+		linec, errc1 := pipeline.Generate(pipeline.Stage(ctx, "read"), read)
+		groupc, errc2 := pipeline.Group(pipeline.Stage(ctx, "group"), linec, e.groupByRideID)
+		errcs = append(errcs, errc1, errc2)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range groupc {
+				select {
+				case <-ctx.Done():
+					return
+				case outc <- group:
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(outc)
+		close(done)
+	}()
+
+	return outc, append(errcs, done)
+}
@@ -0,0 +1,118 @@
+package fare
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlignChunk(t *testing.T) {
+	input := "1,a\n1,b\n2,a\n\n2,b\n3,a\n"
+	rideID := func(line []byte) ([]byte, bool) {
+		line = bytes.TrimRight(line, "\n")
+		if len(line) == 0 {
+			return nil, false
+		}
+		return bytes.Split(line, []byte{','})[0], true
+	}
+	tests := []struct {
+		name    string
+		offset  int64
+		aligned int64
+	}{
+		{name: "start of the input", offset: 0, aligned: 8},
+		{name: "start of a line", offset: 4, aligned: 8},
+		{name: "middle of a line", offset: 2, aligned: 8},
+		{name: "before an empty line", offset: 9, aligned: 17},
+		{name: "last ride", offset: 17, aligned: 21},
+		{name: "end of the input", offset: 20, aligned: 21},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			aligned, err := alignChunk(strings.NewReader(input), int64(len(input)), test.offset, rideID)
+			assert.Nil(t, err)
+			assert.Equal(t, test.aligned, aligned)
+		})
+	}
+}
+
+func TestEstimator_chunkInput(t *testing.T) {
+	input := "ts,id,lat,lng\n"
+	for id := 1; id <= 20; id++ {
+		for i := 0; i < 5; i++ {
+			input += fmt.Sprintf("%d,%d,37.9666%02d,23.728308\n", 1405594957+i*10, id, i)
+		}
+	}
+
+	conf := &Config{
+		MaxSpeed:       100,
+		Concurrency:    1,
+		ParallelChunks: 4,
+		Schema:         Schema{Header: true, RideID: "id", Lat: "lat", Lng: "lng", Timestamp: "ts"},
+	}
+	e, err := NewEstimator(strings.NewReader(input), ioutil.Discard, conf)
+	assert.Nil(t, err)
+
+	chunked, err := e.chunkInput()
+	assert.Nil(t, err)
+	assert.Len(t, chunked.chunks, 4)
+	assert.Equal(t, "ts,id,lat,lng\n", string(chunked.header))
+
+	ridec, _ := e.parseChunks(context.TODO(), chunked)
+	lines := strings.Split(input, "\n")
+	rides := 0
+	for group := range ridec {
+		rides++
+		assert.Len(t, group, 5)
+		for _, rec := range group {
+			// the line number is the line of the whole input
+			fields := strings.Split(lines[rec.number-1], ",")
+			assert.Equal(t, Line{fields[1], fields[2], fields[3], fields[0]}, rec.line)
+		}
+	}
+	assert.Equal(t, 20, rides)
+}
+
+func TestEstimator_chunkInput_notChunked(t *testing.T) {
+	conf := &Config{MaxSpeed: 100, Concurrency: 1, ParallelChunks: 4}
+
+	e, err := NewEstimator(bytes.NewBufferString("1,37.966660,23.728308,1405594957\n"), ioutil.Discard, conf)
+	assert.Nil(t, err)
+	chunked, err := e.chunkInput()
+	assert.Nil(t, err)
+	assert.Nil(t, chunked, "not seekable")
+
+	e, err = NewEstimator(bytes.NewReader(gzipped(t, "1,37.966660,23.728308,1405594957\n")), ioutil.Discard, conf)
+	assert.Nil(t, err)
+	chunked, err = e.chunkInput()
+	assert.Nil(t, err)
+	assert.Nil(t, chunked, "compressed")
+}
+
+func TestEstimator_Run_parallelChunks(t *testing.T) {
+	data := string(benchmarkInput(50))
+	for _, fast := range []bool{false, true} {
+		out := &bytes.Buffer{}
+		conf := &Config{MaxSpeed: 100, Concurrency: 4, ParallelChunks: 7, FastCSV: fast}
+		e, err := NewEstimator(strings.NewReader(data), out, conf)
+		assert.Nil(t, err)
+		assert.Nil(t, e.Run(context.TODO()))
+
+		want := &bytes.Buffer{}
+		e, err = NewEstimator(strings.NewReader(data), want, &Config{MaxSpeed: 100, Concurrency: 1})
+		assert.Nil(t, err)
+		assert.Nil(t, e.Run(context.TODO()))
+
+		got := strings.Split(strings.TrimSpace(out.String()), "\n")
+		sort.Strings(got)
+		wantLines := strings.Split(strings.TrimSpace(want.String()), "\n")
+		sort.Strings(wantLines)
+		assert.Equal(t, wantLines, got)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cubny/fare"
+)
+
+// stdio is the path of stdin and stdout
+const stdio = "-"
+
+// inputPaths expands the comma separated input paths, each one is a file, a directory of files,
+// a glob pattern or - for stdin. The files of directories and glob patterns are in lexical order
+func inputPaths(input string) ([]string, error) {
+	var paths []string
+	for _, pattern := range strings.Split(input, ",") {
+		if pattern == stdio {
+			paths = append(paths, stdio)
+			continue
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no such file: %s", pattern)
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				paths = append(paths, match)
+				continue
+			}
+
+			files, err := ioutil.ReadDir(match)
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range files {
+				if file.Mode().IsRegular() && !strings.HasPrefix(file.Name(), ".") {
+					paths = append(paths, filepath.Join(match, file.Name()))
+				}
+			}
+		}
+	}
+
+	return paths, nil
+}
+
+// inputFile is an input file which is opened on its first read and closed at its end,
+// so that many input files are not open at once
+type inputFile struct {
+	path string
+	file *os.File
+	done bool
+}
+
+// Name returns the path of the file
+func (f *inputFile) Name() string {
+	return f.path
+}
+
+func (f *inputFile) Read(p []byte) (int, error) {
+	if f.done {
+		return 0, io.EOF
+	}
+	if f.file == nil {
+		file, err := os.Open(f.path)
+		if err != nil {
+			return 0, err
+		}
+		f.file = file
+	}
+
+	n, err := f.file.Read(p)
+	if err == io.EOF {
+		f.done = true
+		if err := f.Close(); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+// Close closes the file if it is open
+func (f *inputFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// openInputs opens the input paths, stdin for -, the files of multiple paths are opened when they are read
+func openInputs(paths []string) ([]io.ReadCloser, error) {
+	inputs := make([]io.ReadCloser, 0, len(paths))
+	for _, path := range paths {
+		switch {
+		case path == stdio:
+			inputs = append(inputs, os.Stdin)
+		case len(paths) == 1:
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, file)
+		default:
+			inputs = append(inputs, &inputFile{path: path})
+		}
+	}
+	return inputs, nil
+}
+
+// createOutput creates the output file of the path, stdout for -
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == stdio {
+		// stdout is left open, it is closed at exit
+		return nopCloser{os.Stdout}, nil
+	}
+	return fare.CreateOutput(path)
+}
+
+// stdoutCount returns the number of output paths which are stdout
+func stdoutCount(paths ...string) int {
+	count := 0
+	for _, path := range paths {
+		if path == stdio {
+			count++
+		}
+	}
+	return count
+}
+
+// resumable checks if an output of the path can be resumed, stdout and gzip compressed outputs can't
+func resumable(path string) bool {
+	return path != stdio && !strings.HasSuffix(path, ".gz")
+}
+
+// resumeOutput opens the existing output file of the path to resume writing it at the offset,
+// the rest of the file is truncated
+func resumeOutput(path string, offset int64) (io.WriteCloser, error) {
+	if !resumable(path) {
+		return nil, fmt.Errorf("can not resume %s, resumed outputs should be uncompressed files", path)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// nopCloser is a writer with a Close method which does nothing
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -5,47 +5,238 @@ import (
 	"flag"
 	"fmt"
 	"github.com/cubny/fare"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 const maxSpeed = 100
 
 func main() {
-	infile := flag.String("input", "", "input csv file path")
-	outfile := flag.String("output", "fares.csv", "output csv file path")
+	infile := flag.String("input", "", "comma separated input files, directories or glob patterns read as one stream, - for stdin, gzip compressed input is detected")
+	outfile := flag.String("output", "fares.csv", "output file path, - for stdout, gzip compressed if it ends in .gz")
+	qualityfile := flag.String("quality", "", "optional data quality report file path, gzip compressed if it ends in .gz")
+	deadLetterfile := flag.String("dead-letter", "", "optional file path of the rejected lines and the failed rides, gzip compressed if it ends in .gz")
+	inputFormat := flag.String("input-format", "csv", "input format: csv, ndjson, gpx or geojson")
+	outputFormat := flag.String("output-format", "csv", "output format of the fares and the quality report: csv, ndjson or geojson, the quality report of geojson is csv")
+	outputColumns := flag.String("output-columns", "", "comma separated columns of the csv output: id_ride, fare, started_at, finished_at, start_lat, start_lng, end_lat, end_lng, distance, moving, idle, avg_speed, max_speed and segments (default id_ride,fare)")
 	concurrency := flag.Int("c", 5, "concurrent workers")
+	distance := flag.String("distance", "haversine", "distance model: haversine, vincenty or equirectangular")
+	timestamp := flag.String("timestamp", "auto", "timestamp format: auto, s, ms, us or rfc3339")
+	tripGap := flag.Duration("trip-gap", 0, "split rides into trips on time gaps of at least this duration, e.g. 30m")
+	tripStationary := flag.Duration("trip-stationary", 0, "split rides into trips on stationary periods of at least this duration, e.g. 15m")
+	stationaryRadius := flag.Float64("stationary-radius", 0.05, "radius in km in which a ride is considered stationary")
+	unsorted := flag.Bool("unsorted", false, "sort the input by ride id and timestamp before estimating, for unsorted input files")
+	sortChunk := flag.Int("sort-chunk", 100000, "number of lines sorted in memory before spilling to disk, with -unsorted")
+	tempDir := flag.String("tmp", "", "directory of temporary files, with -unsorted (default the system temp directory)")
+	header := flag.Bool("header", false, "the first line of the input is a header")
+	columns := flag.String("columns", "", "comma separated columns of ride id, lat, lng and timestamp, as header names or 0-based indexes (default the first four columns in order)")
+	extra := flag.String("extra", "", "comma separated extra columns to write along with the fares, as header names or 0-based indexes")
+	delimiter := flag.String("delimiter", ",", "field delimiter of the input, \"tab\" for tab")
+	comment := flag.String("comment", "", "character which starts a comment line in the input")
+	ordered := flag.Bool("ordered", false, "write the fares in the order of the rides in the input")
+	orderWindow := flag.Int("order-window", 0, "maximum number of rides estimated or held back at once, with -ordered (default 4 for each worker)")
+	parallel := flag.Int("parallel", 0, "split a single csv input file into this many chunks aligned to the rides and parse them concurrently, the output is unordered")
+	fastCSV := flag.Bool("fast-csv", false, "read the plain four-column csv input without quotes with a faster parser")
+	checkpoint := flag.String("checkpoint", "", "checkpoint file path, the progress is saved to it periodically and on interrupt, implies -ordered")
+	checkpointInterval := flag.Duration("checkpoint-interval", 10*time.Second, "interval of saving the checkpoint, with -checkpoint")
+	resume := flag.Bool("resume", false, "resume an interrupted run from its -checkpoint, the output files are truncated to the checkpoint")
+	partition := flag.Bool("partition", false, "write the fares into the date partitions of the -output directory by the start of the rides in UTC, e.g. out/date=2014-07-17/part-0.csv")
+	printStats := flag.Bool("stats", false, "print the metrics of the stages of the pipelines to stderr at exit")
+	errorPolicy := flag.String("errors", "skip", "policy of the failed rides: skip, fail-fast, fail-after=N to fail after more than N failed rides or fail-above=R to fail if the ratio of the failed rides is above R")
+	failInvalid := flag.Bool("fail-invalid", false, "leave out the rides with invalid positions instead of skipping the positions")
 	flag.Parse()
 
-	in, err := os.Open(*infile)
+	// an interrupted run is resumed from its outputs, so they should be resumable from the start
+	if *checkpoint != "" && !*partition {
+		for _, path := range []string{*outfile, *qualityfile, *deadLetterfile} {
+			if path != "" && !resumable(path) {
+				log.Fatalf("-checkpoint can not be used with the output %s, the outputs should be uncompressed files\n", path)
+			}
+		}
+	}
+
+	var resumed *fare.Checkpoint
+	if *resume {
+		if *checkpoint == "" {
+			log.Fatalf("-resume needs a -checkpoint\n")
+		}
+		var err error
+		resumed, err = fare.ReadCheckpoint(*checkpoint)
+		if err != nil {
+			log.Fatalf("read checkpoint: %s\n", err)
+		}
+	}
+
+	if stdoutCount(*outfile, *qualityfile, *deadLetterfile) > 1 {
+		log.Fatalf("only one of -output, -quality and -dead-letter can be - for stdout\n")
+	}
+
+	if *infile == "" {
+		log.Fatalf("-input is required, - reads from stdin\n")
+	}
+	paths, err := inputPaths(*infile)
+	if err != nil {
+		log.Fatalf("input: %s\n", err)
+	}
+	inputs, err := openInputs(paths)
 	if err != nil {
 		log.Fatalf("open input file: %s\n", err)
 	}
 
-	out, err := os.Create(*outfile)
+	var out io.WriteCloser
+	switch {
+	case *partition:
+		// the partitions are written by the estimator
+		if *outfile == stdio {
+			log.Fatalf("-partition needs an -output directory\n")
+		}
+		out = nopCloser{ioutil.Discard}
+	case resumed != nil:
+		out, err = resumeOutput(*outfile, resumed.OutputOffset)
+	default:
+		out, err = createOutput(*outfile)
+	}
 	if err != nil {
 		log.Fatalf("open output in: %s\n", err)
 	}
 
+	var quality io.WriteCloser
+	if *qualityfile != "" {
+		if resumed != nil {
+			quality, err = resumeOutput(*qualityfile, resumed.QualityOffset)
+		} else {
+			quality, err = createOutput(*qualityfile)
+		}
+		if err != nil {
+			log.Fatalf("open quality report file: %s\n", err)
+		}
+	}
+
+	var deadLetter io.WriteCloser
+	if *deadLetterfile != "" {
+		if resumed != nil {
+			deadLetter, err = resumeOutput(*deadLetterfile, resumed.DeadLetterOffset)
+		} else {
+			deadLetter, err = createOutput(*deadLetterfile)
+		}
+		if err != nil {
+			log.Fatalf("open dead letter file: %s\n", err)
+		}
+	}
+
 	defer func() {
-		if err := in.Close(); err != nil {
-			log.Fatalf("close input file: %s\n", err)
+		for _, in := range inputs {
+			if err := in.Close(); err != nil {
+				log.Fatalf("close input file: %s\n", err)
+			}
 		}
 		if err := out.Close(); err != nil {
 			log.Fatalf("close output file: %s\n", err)
 		}
+		if quality != nil {
+			if err := quality.Close(); err != nil {
+				log.Fatalf("close quality report file: %s\n", err)
+			}
+		}
+		if deadLetter != nil {
+			if err := deadLetter.Close(); err != nil {
+				log.Fatalf("close dead letter file: %s\n", err)
+			}
+		}
 	}()
 
 	config := &fare.Config{
-		MaxSpeed:    maxSpeed,
-		Concurrency: *concurrency,
+		MaxSpeed:           maxSpeed,
+		Concurrency:        *concurrency,
+		TripGap:            *tripGap,
+		TripStationary:     *tripStationary,
+		StationaryRadius:   *stationaryRadius,
+		Unsorted:           *unsorted,
+		SortChunkSize:      *sortChunk,
+		TempDir:            *tempDir,
+		FastCSV:            *fastCSV,
+		ParallelChunks:     *parallel,
+		Ordered:            *ordered || *checkpoint != "",
+		OrderWindow:        *orderWindow,
+		Checkpoint:         *checkpoint,
+		CheckpointInterval: *checkpointInterval,
+		Resume:             resumed,
+		InputFormat:        fare.Format(*inputFormat),
+		OutputFormat:       fare.Format(*outputFormat),
+	}
+	if *outputColumns != "" {
+		config.Columns = strings.Split(*outputColumns, ",")
+	}
+	if *partition {
+		config.PartitionDir = *outfile
+	}
+	if *failInvalid {
+		config.InvalidPositions = fare.FailInvalidPositions
+	}
+	config.ErrorPolicy, err = parseErrorPolicy(*errorPolicy)
+	if err != nil {
+		log.Fatalf("errors: %s\n", err)
+	}
+	schema, err := parseSchema(*header, *columns, *extra, *delimiter, *comment)
+	if err != nil {
+		log.Fatalf("schema: %s\n", err)
 	}
+	config.Schema = schema
 
-	estimator, err := fare.NewEstimator(in, out, config)
+	switch *distance {
+	case "haversine":
+		config.Distance = fare.Haversine
+	case "vincenty":
+		config.Distance = fare.Vincenty
+	case "equirectangular":
+		config.Distance = fare.Equirectangular
+	default:
+		log.Fatalf("unknown distance model: %s\n", *distance)
+	}
+	switch *timestamp {
+	case "auto":
+		config.TimestampFormat = fare.TimestampAuto
+	case "s":
+		config.TimestampFormat = fare.TimestampSeconds
+	case "ms":
+		config.TimestampFormat = fare.TimestampMillis
+	case "us":
+		config.TimestampFormat = fare.TimestampMicros
+	case "rfc3339":
+		config.TimestampFormat = fare.TimestampRFC3339
+	default:
+		log.Fatalf("unknown timestamp format: %s\n", *timestamp)
+	}
+
+	estimator, err := fare.NewEstimator(inputs[0], out, config)
 	if err != nil {
 		log.Fatalf("NewEstimator: %s\n", err)
 	}
+	if len(inputs) > 1 {
+		readers := make([]io.Reader, len(inputs))
+		for i, in := range inputs {
+			readers[i] = in
+		}
+		estimator.WithInputs(readers...)
+	}
+	if quality != nil {
+		estimator.WithQualityReport(quality)
+	}
+	if deadLetter != nil {
+		estimator.WithDeadLetter(deadLetter)
+	}
+	var stats *fare.Stats
+	if *printStats {
+		stats = fare.NewStats()
+		estimator.WithStats(stats)
+	}
 
 	ctx, stop := context.WithCancel(context.Background())
 
@@ -59,13 +250,95 @@ func main() {
 	}()
 
 	go func() {
-		if err := estimator.Run(ctx); err != nil {
+		err := estimator.Run(ctx)
+		if stats != nil {
+			stats.WriteTo(os.Stderr)
+		}
+		if err != nil {
+			if ctx.Err() != nil && *checkpoint != "" {
+				log.Fatalf("interrupted, continue with -resume -checkpoint %s\n", *checkpoint)
+			}
 			log.Fatalf("estimator: %s\n", err)
 		}
 		exit <- struct{}{}
 	}()
 
 	<-exit
-	fmt.Printf("output is written to %s\n", *outfile)
-	fmt.Println("exit.")
+	// the output may be stdout, so the messages go to stderr
+	fmt.Fprintf(os.Stderr, "output is written to %s\n", *outfile)
+	if quality != nil {
+		fmt.Fprintf(os.Stderr, "quality report is written to %s\n", *qualityfile)
+	}
+	if deadLetter != nil {
+		fmt.Fprintf(os.Stderr, "dead letters are written to %s\n", *deadLetterfile)
+	}
+	if summary := estimator.ErrorSummary(); summary != nil {
+		fmt.Fprintf(os.Stderr, "%d of %d rides failed and are skipped\n", summary.Failed, summary.Items)
+	}
+	fmt.Fprintln(os.Stderr, "exit.")
+}
+
+// parseErrorPolicy parses the policy of the failed rides of the form skip, fail-fast, fail-after=N or fail-above=R
+func parseErrorPolicy(policy string) (fare.ErrorPolicy, error) {
+	name, limit := policy, ""
+	if i := strings.Index(policy, "="); i >= 0 {
+		name, limit = policy[:i], policy[i+1:]
+	}
+
+	var err error
+	p := fare.ErrorPolicy{}
+	switch name {
+	case "skip":
+		p.Mode = fare.SkipErrors
+	case "fail-fast":
+		p.Mode = fare.FailFast
+	case "fail-after":
+		p.Mode = fare.FailAfter
+		p.MaxErrors, err = strconv.Atoi(limit)
+	case "fail-above":
+		p.Mode = fare.FailAboveRatio
+		p.MaxRatio, err = strconv.ParseFloat(limit, 64)
+	default:
+		return p, fmt.Errorf("unknown policy %q", policy)
+	}
+	if err != nil {
+		return p, fmt.Errorf("invalid limit of %q", policy)
+	}
+	if limit != "" && (p.Mode == fare.SkipErrors || p.Mode == fare.FailFast) {
+		return p, fmt.Errorf("%s has no limit", name)
+	}
+	return p, p.Validate()
+}
+
+// parseSchema creates the input schema out of the command line flags
+func parseSchema(header bool, columns, extra, delimiter, comment string) (fare.Schema, error) {
+	schema := fare.Schema{Header: header}
+	if columns != "" {
+		refs := strings.Split(columns, ",")
+		if len(refs) != 4 {
+			return schema, fmt.Errorf("expected 4 columns, got %d", len(refs))
+		}
+		schema.RideID, schema.Lat, schema.Lng, schema.Timestamp = refs[0], refs[1], refs[2], refs[3]
+	}
+	if extra != "" {
+		schema.Extra = strings.Split(extra, ",")
+	}
+
+	if delimiter == "tab" {
+		delimiter = "\t"
+	}
+	if utf8.RuneCountInString(delimiter) != 1 {
+		return schema, fmt.Errorf("delimiter should be a single character: %q", delimiter)
+	}
+	schema.Comma, _ = utf8.DecodeRuneInString(delimiter)
+
+	switch utf8.RuneCountInString(comment) {
+	case 0:
+	case 1:
+		schema.Comment, _ = utf8.DecodeRuneInString(comment)
+	default:
+		return schema, fmt.Errorf("comment should be a single character: %q", comment)
+	}
+
+	return schema, nil
 }
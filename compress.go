@@ -0,0 +1,59 @@
+package fare
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+)
+
+// gzipMagic are the first bytes of gzip compressed data
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decompress returns a reader which decompresses r on the fly if it is gzip compressed, detected by its magic bytes,
+// otherwise it returns a reader of r as it is
+func decompress(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(len(gzipMagic))
+	switch {
+	case err == io.EOF:
+		// too short to be compressed
+		return buffered, nil
+	case err != nil:
+		return nil, err
+	case bytes.Equal(magic, gzipMagic):
+		return gzip.NewReader(buffered)
+	default:
+		return buffered, nil
+	}
+}
+
+// compressedFile is a file which is written through a gzip writer
+type compressedFile struct {
+	*gzip.Writer
+	file *os.File
+}
+
+// Close flushes the compressed data and closes the file
+func (f *compressedFile) Close() error {
+	if err := f.Writer.Close(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+// CreateOutput creates the output file of the path, if the path ends in .gz the output is gzip compressed
+// the output must be closed to flush the compressed data
+func CreateOutput(path string) (io.WriteCloser, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return file, nil
+	}
+	return &compressedFile{Writer: gzip.NewWriter(file), file: file}, nil
+}
@@ -0,0 +1,89 @@
+package fare
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipped(t *testing.T, data string) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	_, err := w.Write([]byte(data))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	data := "1,37.966660,23.728308,1405594957\n"
+	tests := []struct {
+		name  string
+		input []byte
+		data  string
+	}{
+		{
+			name:  "plain",
+			input: []byte(data),
+			data:  data,
+		},
+		{
+			name:  "gzip compressed",
+			input: gzipped(t, data),
+			data:  data,
+		},
+		{
+			name:  "too short to be compressed",
+			input: []byte("1"),
+			data:  "1",
+		},
+		{
+			name:  "empty",
+			input: []byte{},
+			data:  "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := decompress(bytes.NewReader(test.input))
+			assert.Nil(t, err)
+			got, err := ioutil.ReadAll(r)
+			assert.Nil(t, err)
+			assert.Equal(t, test.data, string(got))
+		})
+	}
+}
+
+func TestCreateOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fare")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"fares.csv", "fares.csv.gz"} {
+		path := filepath.Join(dir, name)
+		out, err := CreateOutput(path)
+		assert.Nil(t, err)
+		_, err = out.Write([]byte("1,3.47\n"))
+		assert.Nil(t, err)
+		assert.Nil(t, out.Close())
+
+		written, err := ioutil.ReadFile(path)
+		assert.Nil(t, err)
+		if strings.HasSuffix(name, ".gz") {
+			assert.Equal(t, gzipMagic, written[:2])
+		}
+		r, err := decompress(bytes.NewReader(written))
+		assert.Nil(t, err)
+		got, err := ioutil.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Equal(t, "1,3.47\n", string(got))
+	}
+}
@@ -0,0 +1,138 @@
+package fare
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// kinds of dead letters
+	deadLetterLine = "line"
+	deadLetterRide = "ride"
+)
+
+// deadLetter is a line or a ride which is rejected
+type deadLetter struct {
+	kind string
+	// line is the line number of the line or the first line of the ride, 0 if it is not known
+	line   int
+	rideID string
+	reason string
+	// data is the rejected line, nil for rides
+	data Line
+}
+
+// deadLetterSink writes the dead letters to an output
+type deadLetterSink interface {
+	write(d deadLetter) error
+	// flush writes any buffered data to the output
+	flush() error
+}
+
+// newDeadLetterSink creates a deadLetterSink which writes in the format, the dead letters of the GeoJSON output are CSV
+func newDeadLetterSink(w io.Writer, format Format) deadLetterSink {
+	if format == FormatNDJSON {
+		return newNDJSONDeadLetterSink(w)
+	}
+	return newCSVDeadLetterSink(w)
+}
+
+// rideDeadLetters returns the dead letters of a ride, which are its rejected lines
+// and the ride itself if it failed, unless the run is canceled
+func (e *estimator) rideDeadLetters(ctx context.Context, r *ride, group []record, err error) []deadLetter {
+	if e.deadLetter == nil {
+		return nil
+	}
+	var deadLetters []deadLetter
+	if r != nil {
+		r.mu.Lock()
+		deadLetters = r.rejected
+		r.mu.Unlock()
+	}
+	if err == nil || ctx.Err() != nil {
+		return deadLetters
+	}
+
+	first := group[0]
+	line := first.number
+	var perr *PositionError
+	if errors.As(err, &perr) && perr.Line > 0 {
+		line = perr.Line
+	}
+	return append(deadLetters, deadLetter{kind: deadLetterRide, line: line, rideID: first.rideID(), reason: err.Error()})
+}
+
+// lineReason returns the reason of a rejected line which names the field and the value of a PositionError
+func lineReason(err error) string {
+	var perr *PositionError
+	if errors.As(err, &perr) {
+		return fmt.Sprintf("%s %q: %s", perr.Field, perr.Value, perr.Err)
+	}
+	var lerr *lineError
+	if errors.As(err, &lerr) {
+		return lerr.err.Error()
+	}
+	return err.Error()
+}
+
+// csvDeadLetterSink writes the dead letters as csv records of the form kind, line, id_ride, reason, data
+// where data is the rejected line joined by commas
+type csvDeadLetterSink struct {
+	writer *csv.Writer
+}
+
+// newCSVDeadLetterSink creates a csvDeadLetterSink
+func newCSVDeadLetterSink(w io.Writer) *csvDeadLetterSink {
+	return &csvDeadLetterSink{writer: csv.NewWriter(w)}
+}
+
+func (s *csvDeadLetterSink) write(d deadLetter) error {
+	return s.writer.Write(Line{d.kind, strconv.Itoa(d.line), d.rideID, d.reason, strings.Join(d.data, ",")})
+}
+
+func (s *csvDeadLetterSink) flush() error {
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+// ndjsonDeadLetter is the NDJSON output of a dead letter
+type ndjsonDeadLetter struct {
+	Kind   string   `json:"kind"`
+	Line   int      `json:"line"`
+	RideID string   `json:"id_ride"`
+	Reason string   `json:"reason"`
+	Data   []string `json:"data,omitempty"`
+}
+
+// ndjsonDeadLetterSink writes the dead letters as NDJSON objects
+type ndjsonDeadLetterSink struct {
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+// newNDJSONDeadLetterSink creates a ndjsonDeadLetterSink
+func newNDJSONDeadLetterSink(w io.Writer) *ndjsonDeadLetterSink {
+	writer := bufio.NewWriter(w)
+	return &ndjsonDeadLetterSink{writer: writer, encoder: json.NewEncoder(writer)}
+}
+
+func (s *ndjsonDeadLetterSink) write(d deadLetter) error {
+	return s.encoder.Encode(ndjsonDeadLetter{
+		Kind:   d.kind,
+		Line:   d.line,
+		RideID: d.rideID,
+		Reason: d.reason,
+		Data:   d.data,
+	})
+}
+
+func (s *ndjsonDeadLetterSink) flush() error {
+	return s.writer.Flush()
+}
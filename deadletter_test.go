@@ -0,0 +1,62 @@
+package fare
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimator_Run_deadLetter(t *testing.T) {
+	data := `1,37.966660,23.728308,1405594957
+1,37.966627,23.728263,a
+1,37.966627,23.728263,1405594966
+2,37.966660,23.728308,1405594957
+2,37.966627,23.728263,1405594966`
+
+	tests := []struct {
+		name       string
+		options    *Config
+		out        string
+		deadLetter string
+	}{
+		{
+			name:       "invalid line is skipped",
+			options:    &Config{MaxSpeed: 100, Concurrency: 1},
+			out:        "1,3.47\n2,3.47\n",
+			deadLetter: "line,2,1,\"timestamp \"\"a\"\": invalid timestamp\",\"1,37.966627,23.728263,a\"\n",
+		},
+		{
+			name:    "invalid line fails the ride",
+			options: &Config{MaxSpeed: 100, Concurrency: 1, InvalidPositions: FailInvalidPositions},
+			out:     "2,3.47\n",
+			deadLetter: "line,2,1,\"timestamp \"\"a\"\": invalid timestamp\",\"1,37.966627,23.728263,a\"\n" +
+				"ride,2,1,\"line 2: ride 1: timestamp \"\"a\"\": invalid timestamp\",\n",
+		},
+		{
+			name:    "ordered ndjson",
+			options: &Config{MaxSpeed: 100, Concurrency: 2, Ordered: true, InvalidPositions: FailInvalidPositions, OutputFormat: FormatNDJSON},
+			out:     "{\"id_ride\":2,\"fare\":3.47}\n",
+			deadLetter: `{"kind":"line","line":2,"id_ride":"1","reason":"timestamp \"a\": invalid timestamp","data":["1","37.966627","23.728263","a"]}` + "\n" +
+				`{"kind":"ride","line":2,"id_ride":"1","reason":"line 2: ride 1: timestamp \"a\": invalid timestamp"}` + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			deadLetter := &bytes.Buffer{}
+
+			estimator, err := NewEstimator(strings.NewReader(data), out, test.options)
+			assert.Nil(t, err)
+
+			err = estimator.WithDeadLetter(deadLetter).Run(context.TODO())
+			assert.Nil(t, err)
+
+			assert.Equal(t, test.out, out.String())
+			assert.Equal(t, test.deadLetter, deadLetter.String())
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package fare
+
+import (
+	"github.com/cubny/fare/internal/equirectangular"
+	"github.com/cubny/fare/internal/haversine"
+	"github.com/cubny/fare/internal/vincenty"
+)
+
+// DistanceFunc returns the distance between two positions in kilometers
+type DistanceFunc func(from, to Position) float64
+
+// Haversine is a DistanceFunc which assumes a spherical earth, it is the default distance model
+func Haversine(from, to Position) float64 {
+	return haversine.Haversine(from.Long, from.Lat, to.Long, to.Lat)
+}
+
+// Vincenty is a DistanceFunc on the WGS-84 ellipsoid, it is the most accurate and the slowest distance model
+func Vincenty(from, to Position) float64 {
+	return vincenty.Vincenty(from.Long, from.Lat, to.Long, to.Lat)
+}
+
+// Equirectangular is a DistanceFunc which approximates the distance on a plane, it is the fastest distance model
+// and accurate enough for short distances
+func Equirectangular(from, to Position) float64 {
+	return equirectangular.Equirectangular(from.Long, from.Lat, to.Long, to.Lat)
+}
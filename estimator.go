@@ -2,11 +2,8 @@ package fare
 
 import (
 	"context"
-	"encoding/csv"
-	"errors"
 	"io"
 	"log"
-	"strconv"
 
 	"github.com/cubny/fare/internal/pipeline"
 )
@@ -14,9 +11,24 @@ import (
 // estimator takes a reader stream of rides' positions and streams out the fare estimate
 // of each ride into the writer stream
 type estimator struct {
-	reader io.Reader
-	writer io.Writer
-	conf   *Config
+	readers    []io.Reader
+	writer     io.Writer
+	quality    io.Writer
+	deadLetter io.Writer
+	stats      *Stats
+	conf       *Config
+	// errors are the errors of the rides of the last run
+	errors *pipeline.Errors
+	// keepSegments keeps the segments of the trips for the sinks of the last run which write them
+	keepSegments bool
+}
+
+// Stats are the metrics of the stages of the estimator and the ride pipelines
+type Stats = pipeline.Stats
+
+// NewStats creates a Stats to collect the metrics of the stages of a run
+func NewStats() *Stats {
+	return pipeline.NewStats()
 }
 
 // NewEstimator creates a estimator struct
@@ -26,98 +38,353 @@ func NewEstimator(in io.Reader, out io.Writer, config *Config) (*estimator, erro
 	}
 
 	return &estimator{
-		reader: in,
-		writer: out,
-		conf:   config,
+		readers: []io.Reader{in},
+		writer:  out,
+		conf:    config,
 	}, nil
 }
 
+// WithInputs makes the estimator read the given inputs instead of the input of NewEstimator,
+// one after another as a single stream, so a ride is not split across two inputs.
+// errors of an input are prefixed by its name if it has a Name method like os.File
+func (e *estimator) WithInputs(inputs ...io.Reader) *estimator {
+	e.readers = inputs
+	return e
+}
+
+// WithQualityReport makes the estimator write the data quality report of each ride
+// into the given writer in the output format
+func (e *estimator) WithQualityReport(w io.Writer) *estimator {
+	e.quality = w
+	return e
+}
+
+// WithDeadLetter makes the estimator write the rejected lines and the failed rides into the given writer
+// along with their line number, ride id and the reason of rejection, in the output format
+func (e *estimator) WithDeadLetter(w io.Writer) *estimator {
+	e.deadLetter = w
+	return e
+}
+
+// WithStats makes the estimator collect the metrics of the stages of the pipelines into the stats
+func (e *estimator) WithStats(stats *Stats) *estimator {
+	e.stats = stats
+	return e
+}
+
+// ErrorSummary returns the summary of the errors of the failed rides of the last run, nil if no ride failed
+func (e *estimator) ErrorSummary() *ErrorSummary {
+	if e.errors == nil {
+		return nil
+	}
+	return e.errors.Summary()
+}
+
 // Run runs the estimator pipeline
+// gzip compressed input is decompressed on the fly.
+// The failed rides are handled by the ErrorPolicy, Run returns an *ErrorSummary if the policy fails the run
 func (e *estimator) Run(ctx context.Context) error {
-	in := csv.NewReader(e.reader)
-	linec, errc1 := pipeline.Generate(ctx, e.streamFromCSV(in))
-	ridec, errc2 := pipeline.Group(ctx, linec, e.groupByRideID)
-	outc, errc3 := pipeline.WorkerPool(ctx, e.conf.Concurrency, ridec, e.estimateRide)
-	if err := e.sinkCSV(ctx, outc); err != nil {
+	if e.stats != nil {
+		ctx = pipeline.WithStats(ctx, e.stats)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	e.errors = pipeline.NewErrors(e.conf.ErrorPolicy, cancel)
+	runner := pipeline.NewRunner(pipeline.WithErrors(ctx, e.errors))
+	ctx = runner.Context()
+	// the outputs are created before the rides are estimated, as they tell if the segments are kept
+	out := e.newOutputs()
+	e.keepSegments = out.keepSegments
+	chunked, err := e.chunkInput()
+	if err != nil {
 		return err
 	}
 
-	errm := pipeline.MergeErrors(ctx, errc1, errc2, errc3)
-	for err := range errm {
-		switch {
-		case err == io.EOF:
-		case err != nil:
+	var ridec <-chan []record
+	var errcs []<-chan error
+	if chunked != nil {
+		ridec, errcs = e.parseChunks(ctx, chunked)
+	} else {
+		in, err := e.source()
+		if err != nil {
 			return err
 		}
+		generate := e.streamFromSource(in)
+		if e.conf.Unsorted {
+			sorted, err := e.sortLines(ctx, in)
+			if err != nil {
+				return err
+			}
+			defer sorted.Close()
+			generate = e.streamFromSorted(sorted)
+		}
+
+		linec, errc1 := pipeline.Generate(pipeline.Stage(ctx, "read"), generate)
+		ridec2, errc2 := pipeline.Group(pipeline.Stage(ctx, "group"), linec, e.groupByRideID)
+		ridec, errcs = ridec2, []<-chan error{errc1, errc2}
 	}
 
-	return nil
+	var outc <-chan event
+	var errc3 <-chan error
+	if e.conf.Ordered {
+		outc, errc3 = pipeline.OrderedWorkerPool(pipeline.Stage(ctx, "estimate"), e.conf.Concurrency, e.conf.orderWindow(), ridec, e.estimateRide)
+	} else {
+		outc, errc3 = pipeline.WorkerPool(pipeline.Stage(ctx, "estimate"), e.conf.Concurrency, ridec, e.estimateRide)
+	}
+	runner.Add(append(errcs, errc3)...)
+	err = runner.Run(func(ctx context.Context) error {
+		return e.sink(ctx, outc, out)
+	})
+	if err != nil {
+		out.discard()
+		return e.failed(err)
+	}
+
+	if err := e.errors.Err(); err != nil {
+		out.discard()
+		return err
+	}
+	return out.commit()
 }
 
-// groupByRideId is a pipeline.belongFunc that groups positions by rideId
-func (e *estimator) groupByRideID(item interface{}, group []interface{}) (bool, error) {
-	line, ok := item.(Line)
-	if !ok {
-		return false, errors.New("item of the wrong type passed")
+// failed returns the error summary if the error policy failed the run, which cancels the stages,
+// otherwise the error of a stage
+func (e *estimator) failed(err error) error {
+	if summary := e.errors.Err(); summary != nil {
+		return summary
 	}
-	first, ok := group[0].(Line)
-	return line[0] == first[0], nil
+	return err
 }
 
-// streamFromCSV returns pipeline.generatFunc that reads one line at a time froma csv.Reader
-func (e *estimator) streamFromCSV(in *csv.Reader) func() (interface{}, error) {
-	return func() (interface{}, error) {
-		record, err := in.Read()
-		return Line(record), err
+// source creates the source of the inputs
+func (e *estimator) source() (source, error) {
+	ids := &defaultRideIDs{}
+	newSource := func(r io.Reader) (source, error) {
+		reader, err := decompress(r)
+		if err != nil {
+			return nil, err
+		}
+		if e.conf.FastCSV {
+			return newFastCSVReader(reader, e.conf.TimestampFormat), nil
+		}
+		return newSource(reader, e.conf.InputFormat, e.conf.Schema, ids), nil
 	}
+
+	if len(e.readers) == 1 {
+		return newSource(e.readers[0])
+	}
+	return &multiSource{inputs: e.readers, newSource: newSource}, nil
 }
 
-// sinkCSVRecord writes a rideFare record to csv.Writer
-func (e *estimator) sinkCSVRecord(w *csv.Writer) func(interface{}) error {
-	return func(val interface{}) error {
-		rideFare, ok := val.(rideFare)
-		if !ok {
-			log.Printf("not of the type ride result")
-			return nil
+// groupByRideId is a pipeline.belongFunc that groups positions by rideId
+// the malformed lines without a ride id belong to the ride around them
+func (e *estimator) groupByRideID(rec record, group []record) (bool, error) {
+	if rec.unknownRide() {
+		return true, nil
+	}
+	for _, member := range group {
+		if !member.unknownRide() {
+			return rec.sameRide(member), nil
 		}
-		fareEstimate := strconv.FormatFloat(float64(rideFare.fare), 'f', 2, 64)
-		rideId := strconv.Itoa(rideFare.rideId)
-		record := Line{rideId, fareEstimate}
-		err := w.Write(record)
-		if err != nil {
-			return err
+	}
+	return true, nil
+}
+
+// streamFromSource returns pipeline.generatFunc that reads one line at a time from a source
+func (e *estimator) streamFromSource(in source) func() (record, bool, error) {
+	return func() (record, bool, error) {
+		rec, err := in.read()
+		return rec, true, err
+	}
+}
+
+// sinkRecord writes a rideFare record to all sinks and a dead letter to the dead letter sink
+// the progress of the rides is passed to the checkpointer if there is one
+func (e *estimator) sinkRecord(out *outputs, checkpoints *checkpointer) func(event) error {
+	return func(val event) error {
+		switch val := val.(type) {
+		case progress:
+			if checkpoints == nil {
+				return nil
+			}
+			return checkpoints.progress(val)
+		case deadLetter:
+			return out.deadLetters.write(val)
+		case rideFare:
+			for _, s := range out.sinks {
+				if err := s.write(val); err != nil {
+					return err
+				}
+			}
 		}
 		return nil
 	}
 }
 
-// sinkCSV writes all rideFare records to estimator writer in CSV format
-func (e *estimator) sinkCSV(ctx context.Context, outc <-chan pipeline.Event) error {
-	output := csv.NewWriter(e.writer)
-	err := pipeline.Sink(ctx, outc, e.sinkCSVRecord(output))
-	if err != nil {
-		return err
+// event is an output of the rides, which is a rideFare, a deadLetter or the progress of the input
+type event interface {
+	isEvent()
+}
+
+func (rideFare) isEvent()   {}
+func (deadLetter) isEvent() {}
+func (progress) isEvent()   {}
+
+// outputs are the sinks of the outputs of a run along with the number of bytes written to each output
+type outputs struct {
+	sinks       []sink
+	deadLetters deadLetterSink
+	// partitions is the sink of the fares if they are partitioned by date, nil otherwise
+	partitions *partitionedSink
+	// output, quality and deadLetter count the bytes written to the outputs, nil if there is no such output
+	output, quality, deadLetter *countingWriter
+	// keepSegments is true if a sink writes the segments of the trips
+	keepSegments bool
+}
+
+// newOutputs creates the sinks of the outputs of the estimator in the output format,
+// the bytes are counted from the offsets of the checkpoint which is resumed
+func (e *estimator) newOutputs() *outputs {
+	var resume Checkpoint
+	if e.conf.Resume != nil {
+		resume = *e.conf.Resume
 	}
 
-	output.Flush()
-	if err := output.Error(); err != nil {
-		return err
+	out := &outputs{}
+	if e.conf.PartitionDir != "" {
+		out.partitions = newPartitionedSink(e.conf.PartitionDir, e.conf.OutputFormat, e.conf.Columns, e.conf.Schema.Extra)
+		out.sinks = []sink{out.partitions}
+	} else {
+		out.output = &countingWriter{writer: e.writer, count: resume.OutputOffset}
+		out.sinks = []sink{newSink(out.output, e.conf.OutputFormat, e.conf.Columns, e.conf.Schema.Extra)}
+	}
+	// the GeoJSON sink draws the trips by their segments
+	out.keepSegments = e.conf.OutputFormat == FormatGeoJSON
+	if e.quality != nil {
+		out.quality = &countingWriter{writer: e.quality, count: resume.QualityOffset}
+		out.sinks = append(out.sinks, newQualitySink(out.quality, e.conf.OutputFormat))
+	}
+	if e.deadLetter != nil {
+		out.deadLetter = &countingWriter{writer: e.deadLetter, count: resume.DeadLetterOffset}
+		out.deadLetters = newDeadLetterSink(out.deadLetter, e.conf.OutputFormat)
+	}
+	return out
+}
+
+// commit commits the date partitions if there are, so their parts are written
+func (o *outputs) commit() error {
+	if o.partitions == nil {
+		return nil
+	}
+	return o.partitions.commit()
+}
+
+// discard removes the date partitions if there are, so no part is written by a failed run
+func (o *outputs) discard() {
+	if o.partitions == nil {
+		return
+	}
+	if err := o.partitions.remove(); err != nil {
+		log.Printf("%s", err)
 	}
+}
 
+// flush flushes all sinks
+func (o *outputs) flush() error {
+	for _, s := range o.sinks {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	if o.deadLetters != nil {
+		return o.deadLetters.flush()
+	}
 	return nil
 }
 
-// estimateRide is a pipeline.workerFunc that runs the rideEstimator pipeline for each ride
-func (e *estimator) estimateRide(ctx context.Context, items interface{}, outc chan<- pipeline.Event) error {
-	var lines []Line
-	for _, item := range items.([]interface{}) {
-		lines = append(lines, item.(Line))
+// sink writes all rideFare records to estimator writer, or its date partitions, and to the quality writer
+// if there is one, in the output format, as well as the dead letters to the dead letter writer if there is one.
+// With a Checkpoint, the checkpoint is written periodically, at the end and when the run is canceled
+func (e *estimator) sink(ctx context.Context, outc <-chan event, out *outputs) error {
+	var checkpoints *checkpointer
+	if e.conf.Checkpoint != "" {
+		checkpoints = newCheckpointer(e.conf, out)
+	}
+
+	err := pipeline.Sink(pipeline.Stage(ctx, "write"), outc, e.sinkRecord(out, checkpoints))
+	if err != nil {
+		if checkpoints != nil && ctx.Err() != nil {
+			// keep the progress of the canceled run
+			if err := checkpoints.save(); err != nil {
+				log.Printf("checkpoint: %s", err)
+			}
+		}
+		return err
 	}
 
+	if checkpoints != nil {
+		return checkpoints.save()
+	}
+	return out.flush()
+}
+
+// estimateRide is a pipeline.workerFunc that runs the rideEstimator pipeline for each ride, a failed ride
+// is left out of the output and its error is returned to the ErrorPolicy. The fares of the ride are followed
+// by its dead letters and with a Checkpoint, by the progress of the input after the ride
+func (e *estimator) estimateRide(ctx context.Context, group []record, outc chan<- event) error {
+	lines, numbers, parsed, malformed := e.rideLines(group)
+
 	rideEstimator, err := newRide(lines, e.conf)
 	if err != nil {
 		return err
 	}
-	rideEstimator.run(ctx, outc)
-	return nil
+	rideEstimator.numbers = numbers
+	rideEstimator.parsed = parsed
+	rideEstimator.malformed = malformed
+	rideEstimator.keepSegments = e.keepSegments
+	err = rideEstimator.run(ctx, outc)
+	if err != nil {
+		log.Printf("failed ride: %s", err)
+	}
+
+	for _, d := range e.rideDeadLetters(ctx, rideEstimator, group, err) {
+		select {
+		case <-ctx.Done():
+			return err
+		case outc <- d:
+		}
+	}
+	if e.conf.Checkpoint != "" {
+		select {
+		case <-ctx.Done():
+		case outc <- progress{offset: group[len(group)-1].offset}:
+		}
+	}
+	return err
+}
+
+// rideLines returns the lines of a group of records along with their line numbers
+// the lines which are parsed by the source are nil, their positions are returned in parsed
+// which is nil if no line is parsed. The reasons of the malformed lines are returned in malformed
+// which is nil if no line is malformed
+func (e *estimator) rideLines(group []record) (lines []Line, numbers []int, parsed []Position, malformed []error) {
+	lines = make([]Line, 0, len(group))
+	numbers = make([]int, 0, len(group))
+	for i, rec := range group {
+		lines = append(lines, rec.line)
+		numbers = append(numbers, rec.number)
+		if rec.line == nil {
+			if parsed == nil {
+				parsed = make([]Position, len(group))
+			}
+			parsed[i] = rec.position
+		}
+		if rec.malformed != nil {
+			if malformed == nil {
+				malformed = make([]error, len(group))
+			}
+			malformed[i] = rec.malformed
+		}
+	}
+	return lines, numbers, parsed, malformed
 }
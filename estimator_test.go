@@ -3,8 +3,13 @@ package fare
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -31,3 +36,572 @@ func TestEstimator_Run(t *testing.T) {
 
 	assert.Equal(t, "1,3.47\n2,3.47\n", out.String())
 }
+
+func TestEstimator_Run_qualityReport(t *testing.T) {
+	data := `1,37.966660,23.728308,1405594957
+1,37.966627,23.728263,a
+1,37.966627,23.728263,1405594966
+2,37.966660,23.728308,1405594957
+2,38.966627,23.728263,1405594966`
+
+	in := strings.NewReader(data)
+	out := &bytes.Buffer{}
+	quality := &bytes.Buffer{}
+
+	options := &Config{
+		MaxSpeed:    100,
+		Concurrency: 1,
+	}
+
+	estimator, err := NewEstimator(in, out, options)
+	assert.Nil(t, err)
+
+	err = estimator.WithQualityReport(quality).Run(context.TODO())
+	assert.Nil(t, err)
+
+	assert.Equal(t, "1,3.47\n2,3.47\n", out.String())
+	assert.Equal(t, "1,2,1,0,2,invalid timestamp=1\n2,2,0,1,1,speed is out of range=1\n", quality.String())
+}
+
+func TestEstimator_Run_trips(t *testing.T) {
+	data := `1,37.966660,23.728308,1405594957
+1,37.966627,23.728263,1405594966
+1,37.966660,23.728308,1405602157
+1,37.966627,23.728263,1405602166
+2,37.966660,23.728308,1405594957
+2,37.966627,23.728263,1405594966`
+
+	in := strings.NewReader(data)
+	out := &bytes.Buffer{}
+	quality := &bytes.Buffer{}
+
+	options := &Config{
+		MaxSpeed:    100,
+		Concurrency: 1,
+		TripGap:     time.Hour,
+	}
+
+	estimator, err := NewEstimator(in, out, options)
+	assert.Nil(t, err)
+
+	err = estimator.WithQualityReport(quality).Run(context.TODO())
+	assert.Nil(t, err)
+
+	assert.Equal(t, "1_1,3.47\n1_2,3.47\n2,3.47\n", out.String())
+	assert.Equal(t, "1,4,0,0,4,\n2,2,0,0,2,\n", quality.String())
+}
+
+func TestEstimator_Run_unsorted(t *testing.T) {
+	data := `2,37.966627,23.728263,1405594966
+1,37.966627,23.728263,1405594966
+10,37.966660,23.728308,1405594957
+2,37.966660,23.728308,1405594957
+1,37.966660,23.728308,1405594957
+10,37.966627,23.728263,1405594966`
+
+	in := strings.NewReader(data)
+	out := &bytes.Buffer{}
+	quality := &bytes.Buffer{}
+
+	options := &Config{
+		MaxSpeed:      100,
+		Concurrency:   1,
+		Unsorted:      true,
+		SortChunkSize: 2,
+	}
+
+	estimator, err := NewEstimator(in, out, options)
+	assert.Nil(t, err)
+
+	err = estimator.WithQualityReport(quality).Run(context.TODO())
+	assert.Nil(t, err)
+
+	assert.Equal(t, "1,3.47\n2,3.47\n10,3.47\n", out.String())
+	// without sorting the second position of each ride would be an outlier going back in time
+	assert.Equal(t, "1,2,0,0,2,\n2,2,0,0,2,\n10,2,0,0,2,\n", quality.String())
+}
+
+func TestEstimator_Run_invalidLineNumber(t *testing.T) {
+	data := `ride;lat;lng;time
+1;37.966660;23.728308;1405594957
+1;37.966627;23.728263;1405594966
+2;37.966660;23.728308;1405594957
+2;37.966627;23.728263`
+
+	in := strings.NewReader(data)
+	out := &bytes.Buffer{}
+	deadLetters := &bytes.Buffer{}
+
+	options := &Config{
+		MaxSpeed:    100,
+		Concurrency: 1,
+		Schema: Schema{
+			Header: true,
+			Comma:  ';',
+		},
+	}
+
+	estimator, err := NewEstimator(in, out, options)
+	assert.Nil(t, err)
+
+	err = estimator.WithDeadLetter(deadLetters).Run(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "1,3.47\n2,3.47\n", out.String())
+	assert.Equal(t, "line,5,2,wrong number of fields,\"2,37.966627,23.728263,\"\n", deadLetters.String())
+}
+
+func TestEstimator_Run_malformedLines(t *testing.T) {
+	tests := []struct {
+		name        string
+		conf        Config
+		data        string
+		output      string
+		deadLetters string
+	}{
+		{
+			name:   "malformed lines are dead letters",
+			data:   "1,37.966660,23.728308,1405594957\n1,37.966660\n1,37.966627,23.728263,1405594966\n2,x\n",
+			output: "1,3.47\n",
+			deadLetters: "line,2,1,wrong number of fields,\"1,37.966660,,\"\n" +
+				"line,4,2,wrong number of fields,\"2,x,,\"\n",
+		},
+		{
+			name:        "malformed lines without a ride id belong to the ride around them",
+			data:        "1,37.966660,23.728308,1405594957\n1,a\"b,,\n1,37.966627,23.728263,1405594966\n",
+			output:      "1,3.47\n",
+			deadLetters: "line,2,,\"bare \"\" in non-quoted-field\",\",,,\"\n",
+		},
+		{
+			name:        "fast csv",
+			conf:        Config{FastCSV: true},
+			data:        "1,37.966660,23.728308,1405594957\n1,37.966660\n1,37.966627,23.728263,1405594966\n",
+			output:      "1,3.47\n",
+			deadLetters: "line,2,1,\"expected 4 columns, got 2\",\"1,37.966660,,\"\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			deadLetters := &bytes.Buffer{}
+			options := test.conf
+			options.MaxSpeed, options.Concurrency = 100, 1
+			estimator, err := NewEstimator(strings.NewReader(test.data), out, &options)
+			assert.Nil(t, err)
+
+			err = estimator.WithDeadLetter(deadLetters).Run(context.TODO())
+			assert.Nil(t, err)
+			assert.Equal(t, test.output, out.String())
+			assert.Equal(t, test.deadLetters, deadLetters.String())
+		})
+	}
+}
+
+func TestEstimator_Run_ndjson(t *testing.T) {
+	data := `{"id_ride":1,"lat":37.966660,"lng":23.728308,"timestamp":1405594957}
+{"id_ride":1,"lat":37.966627,"lng":23.728263,"timestamp":1405594966}
+{"id_ride":2,"lat":37.966660,"lng":23.728308,"timestamp":1405594957}
+{"id_ride":2,"lat":37.966627,"lng":23.728263,"timestamp":1405594966}`
+
+	in := strings.NewReader(data)
+	out := &bytes.Buffer{}
+
+	options := &Config{
+		MaxSpeed:     100,
+		Concurrency:  1,
+		InputFormat:  FormatNDJSON,
+		OutputFormat: FormatNDJSON,
+	}
+
+	estimator, err := NewEstimator(in, out, options)
+	assert.Nil(t, err)
+
+	err = estimator.Run(context.TODO())
+	assert.Nil(t, err)
+
+	assert.Equal(t, "{\"id_ride\":1,\"fare\":3.47}\n{\"id_ride\":2,\"fare\":3.47}\n", out.String())
+}
+
+func TestEstimator_Run_gpx(t *testing.T) {
+	data := `<gpx version="1.1">
+  <trk><number>1</number><trkseg>
+    <trkpt lat="37.966660" lon="23.728308"><time>2014-07-17T11:02:37Z</time></trkpt>
+    <trkpt lat="37.966627" lon="23.728263"><time>2014-07-17T11:02:46Z</time></trkpt>
+  </trkseg></trk>
+  <trk><number>2</number><trkseg>
+    <trkpt lat="37.966660" lon="23.728308"><time>2014-07-17T11:02:37Z</time></trkpt>
+    <trkpt lat="37.966627" lon="23.728263"><time>2014-07-17T11:02:46Z</time></trkpt>
+  </trkseg></trk>
+</gpx>`
+
+	in := strings.NewReader(data)
+	out := &bytes.Buffer{}
+
+	options := &Config{
+		MaxSpeed:    100,
+		Concurrency: 1,
+		InputFormat: FormatGPX,
+	}
+
+	estimator, err := NewEstimator(in, out, options)
+	assert.Nil(t, err)
+
+	err = estimator.Run(context.TODO())
+	assert.Nil(t, err)
+
+	assert.Equal(t, "1,3.47\n2,3.47\n", out.String())
+}
+
+func TestEstimator_Run_gzip(t *testing.T) {
+	data := `1,37.966660,23.728308,1405594957
+1,37.966627,23.728263,1405594966
+2,37.966660,23.728308,1405594957
+2,37.966627,23.728263,1405594966`
+
+	in := bytes.NewReader(gzipped(t, data))
+	out := &bytes.Buffer{}
+
+	options := &Config{
+		MaxSpeed:    100,
+		Concurrency: 1,
+	}
+
+	estimator, err := NewEstimator(in, out, options)
+	assert.Nil(t, err)
+
+	err = estimator.Run(context.TODO())
+	assert.Nil(t, err)
+
+	assert.Equal(t, "1,3.47\n2,3.47\n", out.String())
+}
+
+func TestEstimator_Run_inputs(t *testing.T) {
+	first := `id,lat,lng,ts
+1,37.966660,23.728308,1405594957
+1,37.966627,23.728263,1405594966`
+	second := `id,lat,lng,ts
+1,37.966625,23.728263,1405594974
+2,37.966660,23.728308,1405594957
+2,37.966627,23.728263,1405594966`
+
+	out := &bytes.Buffer{}
+	report := &bytes.Buffer{}
+
+	options := &Config{
+		MaxSpeed:    100,
+		Concurrency: 1,
+		Schema:      Schema{Header: true},
+	}
+
+	estimator, err := NewEstimator(nil, out, options)
+	assert.Nil(t, err)
+	estimator.WithInputs(strings.NewReader(first), bytes.NewReader(gzipped(t, second))).WithQualityReport(report)
+
+	err = estimator.Run(context.TODO())
+	assert.Nil(t, err)
+
+	assert.Equal(t, "1,3.47\n2,3.47\n", out.String())
+	assert.Equal(t, "1,3,0,0,3,\n2,2,0,0,2,\n", report.String())
+}
+
+func TestEstimator_Run_ordered(t *testing.T) {
+	var data, want strings.Builder
+	for id := 1; id <= 50; id++ {
+		fmt.Fprintf(&data, "%d,37.966660,23.728308,1405594957\n%d,37.966627,23.728263,1405594966\n", id, id)
+		fmt.Fprintf(&want, "%d,3.47\n", id)
+	}
+
+	out := &bytes.Buffer{}
+
+	options := &Config{
+		MaxSpeed:    100,
+		Concurrency: 8,
+		Ordered:     true,
+		OrderWindow: 3,
+	}
+
+	estimator, err := NewEstimator(strings.NewReader(data.String()), out, options)
+	assert.Nil(t, err)
+
+	err = estimator.Run(context.TODO())
+	assert.Nil(t, err)
+
+	assert.Equal(t, want.String(), out.String())
+}
+
+func TestEstimator_Run_columns(t *testing.T) {
+	data := `1,37.966660,23.728308,1405594957
+1,37.966627,23.728263,1405594966
+1,37.966625,23.728263,1405594974
+2,37.966660,23.728308,1405594957`
+
+	out := &bytes.Buffer{}
+
+	options := &Config{
+		MaxSpeed:    100,
+		Concurrency: 1,
+		Columns: []string{"id_ride", "fare", "started_at", "finished_at", "start_lat", "start_lng", "end_lat",
+			"end_lng", "distance", "moving", "idle", "avg_speed", "max_speed", "segments"},
+	}
+
+	estimator, err := NewEstimator(strings.NewReader(data), out, options)
+	assert.Nil(t, err)
+
+	err = estimator.Run(context.TODO())
+	assert.Nil(t, err)
+
+	assert.Equal(t, `1,3.47,2014-07-17T11:02:37Z,2014-07-17T11:02:54Z,37.966660,23.728308,37.966625,23.728263,0.006,0,17,1.19,2.16,2
+2,3.47,2014-07-17T11:02:37Z,2014-07-17T11:02:37Z,37.966660,23.728308,37.966660,23.728308,0.000,0,0,0.00,0.00,0
+`, out.String())
+}
+
+func TestEstimator_Run_extra(t *testing.T) {
+	data := `ride,lat,lng,time,driver,car
+1,37.966660,23.728308,1405594957,d1,c1
+1,37.966627,23.728263,1405594966,d1,c2
+2,37.966660,23.728308,1405594957,d2,c3
+`
+
+	tests := []struct {
+		name   string
+		format Format
+		output string
+	}{
+		{
+			name:   "CSV output",
+			format: FormatCSV,
+			output: "1,3.47,d1,c1\n2,3.47,d2,c3\n",
+		},
+		{
+			name:   "NDJSON output",
+			format: FormatNDJSON,
+			output: `{"id_ride":1,"fare":3.47,"extra":{"car":"c1","driver":"d1"}}
+{"id_ride":2,"fare":3.47,"extra":{"car":"c3","driver":"d2"}}
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			options := &Config{
+				MaxSpeed:     100,
+				Concurrency:  1,
+				OutputFormat: test.format,
+				Schema: Schema{
+					Header: true, RideID: "ride", Lat: "lat", Lng: "lng", Timestamp: "time",
+					Extra: []string{"driver", "car"},
+				},
+			}
+
+			estimator, err := NewEstimator(strings.NewReader(data), out, options)
+			assert.Nil(t, err)
+
+			err = estimator.Run(context.TODO())
+			assert.Nil(t, err)
+
+			assert.Equal(t, test.output, out.String())
+		})
+	}
+}
+
+func TestEstimator_Run_fastCSV(t *testing.T) {
+	data := `1,37.966660,23.728308,1405594957
+1,37.966627,23.728263,1405594966
+1,a,23.728263,1405594974
+1,37.966613,23.728375,1405594984
+2,37.966660,23.728308,2014-07-17T11:02:37Z
+2,37.966627,23.728263,1405594966
+`
+
+	run := func(fast bool) (string, string) {
+		out := &bytes.Buffer{}
+		report := &bytes.Buffer{}
+		options := &Config{
+			MaxSpeed:    100,
+			Concurrency: 2,
+			Ordered:     true,
+			FastCSV:     fast,
+		}
+
+		estimator, err := NewEstimator(strings.NewReader(data), out, options)
+		assert.Nil(t, err)
+		err = estimator.WithQualityReport(report).Run(context.TODO())
+		assert.Nil(t, err)
+		return out.String(), report.String()
+	}
+
+	out, report := run(true)
+	assert.Equal(t, "1,3.47\n2,3.47\n", out)
+	assert.Equal(t, "1,3,1,0,3,invalid latitude=1\n2,2,0,0,2,\n", report)
+
+	wantOut, wantReport := run(false)
+	assert.Equal(t, wantOut, out)
+	assert.Equal(t, wantReport, report)
+}
+
+// benchmarkInput returns the CSV input of the given number of rides of 100 positions
+func benchmarkInput(rides int) []byte {
+	var data bytes.Buffer
+	for id := 1; id <= rides; id++ {
+		for i := 0; i < 100; i++ {
+			fmt.Fprintf(&data, "%d,37.%06d,23.%06d,%d\n", id, 966660+i*10, 728308+i*10, 1405594957+i*10)
+		}
+	}
+	return data.Bytes()
+}
+
+func BenchmarkEstimator_streamFromSource(b *testing.B) {
+	data := benchmarkInput(100)
+	for _, fast := range []bool{false, true} {
+		name := "csv"
+		if fast {
+			name = "fast"
+		}
+		b.Run(name, func(b *testing.B) {
+			estimator, err := NewEstimator(nil, ioutil.Discard, &Config{MaxSpeed: 100, Concurrency: 1, FastCSV: fast})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				estimator.readers = []io.Reader{bytes.NewReader(data)}
+				in, err := estimator.source()
+				if err != nil {
+					b.Fatal(err)
+				}
+				generate := estimator.streamFromSource(in)
+				for {
+					if _, _, err := generate(); err == io.EOF {
+						break
+					} else if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEstimator_Run(b *testing.B) {
+	data := benchmarkInput(100)
+	benchmarks := []struct {
+		name string
+		conf Config
+	}{
+		{name: "csv", conf: Config{MaxSpeed: 100, Concurrency: 4}},
+		{name: "fast", conf: Config{MaxSpeed: 100, Concurrency: 4, FastCSV: true}},
+		{name: "parallel", conf: Config{MaxSpeed: 100, Concurrency: 4, ParallelChunks: 4}},
+		{name: "fast parallel", conf: Config{MaxSpeed: 100, Concurrency: 4, FastCSV: true, ParallelChunks: 4}},
+	}
+	for _, bm := range benchmarks {
+		conf := bm.conf
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				estimator, err := NewEstimator(bytes.NewReader(data), ioutil.Discard, &conf)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := estimator.Run(context.TODO()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestEstimator_Run_stats(t *testing.T) {
+	data := `1,37.966660,23.728308,1405594957
+1,37.966627,23.728263,1405594966
+2,37.966660,23.728308,1405594957
+2,37.966627,23.728263,1405594966`
+
+	stats := NewStats()
+	estimator, err := NewEstimator(strings.NewReader(data), ioutil.Discard, &Config{MaxSpeed: 100, Concurrency: 2})
+	assert.Nil(t, err)
+
+	err = estimator.WithStats(stats).Run(context.TODO())
+	assert.Nil(t, err)
+
+	counts := make(map[string][2]int64)
+	for _, stage := range stats.Stages() {
+		counts[stage.Name] = [2]int64{stage.In, stage.Out}
+	}
+	assert.Equal(t, map[string][2]int64{
+		"read":              {0, 4},
+		"group":             {4, 2},
+		"estimate":          {2, 2},
+		"write":             {2, 0},
+		"parse positions":   {0, 4},
+		"collect positions": {4, 0},
+		"trip positions":    {0, 4},
+		"segments":          {4, 2},
+		"fare":              {2, 0},
+	}, counts)
+}
+
+func TestEstimator_Run_errorPolicy(t *testing.T) {
+	data := `1,37.966660,23.728308,1405594957
+1,37.966627,23.728263,a
+2,37.966660,23.728308,1405594957
+2,37.966627,23.728263,1405594966
+3,37.966660,23.728308,1405594957
+3,37.966627,23.728263,1405594966`
+
+	tests := []struct {
+		name   string
+		policy ErrorPolicy
+		failed int
+		err    string
+	}{
+		{
+			name:   "skip",
+			failed: 1,
+		},
+		{
+			name:   "fail fast",
+			policy: ErrorPolicy{Mode: FailFast},
+			failed: 1,
+			err:    `1 of 1 items failed (fail-fast): line 2: ride 1: timestamp "a": invalid timestamp`,
+		},
+		{
+			name:   "ratio is not above the max",
+			policy: ErrorPolicy{Mode: FailAboveRatio, MaxRatio: 0.5},
+			failed: 1,
+		},
+		{
+			name:   "ratio is above the max",
+			policy: ErrorPolicy{Mode: FailAboveRatio, MaxRatio: 0.2},
+			failed: 1,
+			err:    `1 of 3 items failed (fail-above=0.2): line 2: ride 1: timestamp "a": invalid timestamp`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := &Config{
+				MaxSpeed:         100,
+				Concurrency:      1,
+				Ordered:          true,
+				InvalidPositions: FailInvalidPositions,
+				ErrorPolicy:      test.policy,
+			}
+			estimator, err := NewEstimator(strings.NewReader(data), ioutil.Discard, options)
+			assert.Nil(t, err)
+
+			err = estimator.Run(context.TODO())
+			if test.err == "" {
+				assert.Nil(t, err)
+			} else {
+				var summary *ErrorSummary
+				assert.True(t, errors.As(err, &summary))
+				assert.EqualError(t, err, test.err)
+			}
+			assert.Equal(t, test.failed, estimator.ErrorSummary().Failed)
+		})
+	}
+}
@@ -6,7 +6,12 @@
 */
 package fare
 
-import "errors"
+import (
+	"errors"
+	"time"
+
+	"github.com/cubny/fare/internal/pipeline"
+)
 
 // Price is a type for price value
 type Price float32
@@ -24,9 +29,92 @@ const (
 	fareMinimum        = 3.47
 )
 
+// InvalidPositionPolicy decides what happens to a ride when one of its positions is invalid
+type InvalidPositionPolicy int
+
+const (
+	// SkipInvalidPositions skips the invalid positions and estimates the fare with the rest
+	SkipInvalidPositions InvalidPositionPolicy = iota
+	// FailInvalidPositions fails the ride on its first invalid position, so it will not be in the output
+	FailInvalidPositions
+)
+
+// ErrorPolicy decides whether the failed rides fail the run, the zero value skips them
+type ErrorPolicy = pipeline.ErrorPolicy
+
+// ErrorSummary is the summary of the errors of the failed rides
+type ErrorSummary = pipeline.ErrorSummary
+
+const (
+	// SkipErrors skips the failed rides
+	SkipErrors = pipeline.SkipErrors
+	// FailFast fails the run on the first failed ride
+	FailFast = pipeline.FailFast
+	// FailAfter fails the run when more than MaxErrors rides failed
+	FailAfter = pipeline.FailAfter
+	// FailAboveRatio fails the run at the end if the ratio of the failed rides is above MaxRatio
+	FailAboveRatio = pipeline.FailAboveRatio
+)
+
 type Config struct {
-	MaxSpeed    float64
-	Concurrency int
+	MaxSpeed         float64
+	Concurrency      int
+	InvalidPositions InvalidPositionPolicy
+	// Distance is the distance model of segments, nil means Haversine
+	Distance DistanceFunc
+	// TimestampFormat is the format of the input timestamps, detected for each timestamp by default
+	TimestampFormat TimestampFormat
+	// TripGap splits a ride into trips where two positions are at least TripGap apart in time, 0 disables it
+	TripGap time.Duration
+	// TripStationary splits a ride into trips where it stays within StationaryRadius for at least
+	// TripStationary, 0 disables it
+	TripStationary time.Duration
+	// StationaryRadius is the radius in kilometers in which a ride is considered stationary, 0 means 50 meters
+	StationaryRadius float64
+	// Unsorted sorts the input by ride id and timestamp before grouping it into rides
+	// by an external merge sort which spills the sorted chunks of SortChunkSize lines into TempDir
+	Unsorted bool
+	// SortChunkSize is the number of lines which are sorted in memory, 0 means 100000
+	SortChunkSize int
+	// TempDir is the directory of the temporary files, empty means the default directory for temporary files
+	TempDir string
+	// Schema is the layout of the input CSV, the zero value is the four columns of ride id, lat, lng and timestamp
+	// without a header. For NDJSON input the columns are the field names
+	Schema Schema
+	// Ordered emits the fares in the order of the rides in the input, otherwise they are emitted as soon as
+	// they are estimated. At most OrderWindow rides are estimated or held back at once, 0 means 4 for each worker
+	Ordered     bool
+	OrderWindow int
+	// FastCSV reads the input with a scanner which parses the positions without allocating their lines,
+	// it only supports the CSV input of the zero Schema without quotes and can not be Unsorted
+	FastCSV bool
+	// ParallelChunks splits a single CSV input file into this many chunks aligned to the rides, which are
+	// parsed concurrently, so the rides are in no particular order. 0 or 1 reads the input as a whole, as well as
+	// compressed inputs and inputs which can not be read at any offset, like stdin. The quoted fields of the input
+	// should not have line breaks
+	ParallelChunks int
+	// Checkpoint is the path of the checkpoint file, which is written every CheckpointInterval, 0 means 10 seconds,
+	// at the end of the run and when it is canceled. It needs the fares to be Ordered, a single uncompressed CSV
+	// input file and not the GeoJSON output
+	Checkpoint         string
+	CheckpointInterval time.Duration
+	// Resume resumes a run from its checkpoint, the outputs should be truncated to the offsets of the checkpoint
+	Resume *Checkpoint
+	// InputFormat and OutputFormat are the formats of the input and the output, empty means FormatCSV
+	InputFormat  Format
+	OutputFormat Format
+	// Columns are the columns of the CSV output, nil means id_ride and fare
+	Columns []string
+	// PartitionDir writes the fares into part files of the dates of the start of the rides in UTC, of the form
+	// PartitionDir/date=YYYY-MM-DD/part-N.csv, instead of the output. The parts are renamed from temporary files
+	// at the end of the run, so they are not written if it fails. It can not be used with the GeoJSON output
+	// or a Checkpoint
+	PartitionDir string
+	// ErrorPolicy decides whether the failed rides fail the run, by default they are skipped.
+	// It is not applied to reading and grouping the input: the malformed lines are rejected like the invalid
+	// positions of their ride, see InvalidPositions, and the errors of reading the input, e.g. I/O errors or
+	// a GPX or GeoJSON document which can not be decoded, always fail the run as the input can not be read past them
+	ErrorPolicy ErrorPolicy
 }
 
 func (c Config) Validate() error {
@@ -35,7 +123,55 @@ func (c Config) Validate() error {
 		return errors.New("MaxSpeed should be greater than 0")
 	case c.Concurrency == 0:
 		return errors.New("concurrency should be greater than 0")
+	case c.InvalidPositions != SkipInvalidPositions && c.InvalidPositions != FailInvalidPositions:
+		return errors.New("unknown InvalidPositions policy")
+	case c.TimestampFormat < TimestampAuto || c.TimestampFormat > TimestampRFC3339:
+		return errUnknownTimestampFormat
+	case c.TripGap < 0 || c.TripStationary < 0 || c.StationaryRadius < 0:
+		return errors.New("TripGap, TripStationary and StationaryRadius should not be negative")
+	case c.SortChunkSize < 0:
+		return errors.New("SortChunkSize should not be negative")
+	case c.OrderWindow < 0:
+		return errors.New("OrderWindow should not be negative")
+	}
+
+	if err := validInputFormat(c.InputFormat); err != nil {
+		return err
+	}
+	if err := validOutputFormat(c.OutputFormat); err != nil {
+		return err
+	}
+	if c.FastCSV && (c.InputFormat != "" && c.InputFormat != FormatCSV || !c.Schema.plain() || c.Unsorted) {
+		return errors.New("FastCSV only supports the CSV input of the default schema and can not be Unsorted")
+	}
+	if c.Checkpoint != "" && (!c.Ordered || c.OutputFormat == FormatGeoJSON) {
+		return errors.New("Checkpoint needs the fares to be Ordered and can not be used with the GeoJSON output")
+	}
+	if (c.Checkpoint != "" || c.Resume != nil) && (c.InputFormat != "" && c.InputFormat != FormatCSV ||
+		c.Unsorted || c.ParallelChunks > 1) {
+		return errors.New("Checkpoint and Resume only support the CSV input and can not be Unsorted or in ParallelChunks")
+	}
+	if c.CheckpointInterval < 0 {
+		return errors.New("CheckpointInterval should not be negative")
+	}
+	if c.ParallelChunks < 0 {
+		return errors.New("ParallelChunks should not be negative")
+	}
+	if c.ParallelChunks > 1 && (c.InputFormat != "" && c.InputFormat != FormatCSV || c.Ordered || c.Unsorted) {
+		return errParallelChunks
+	}
+	if c.PartitionDir != "" && (c.OutputFormat == FormatGeoJSON || c.Checkpoint != "" || c.Resume != nil) {
+		return errors.New("PartitionDir can not be used with the GeoJSON output or a Checkpoint")
+	}
+	if err := c.ErrorPolicy.Validate(); err != nil {
+		return err
+	}
+	if err := validOutputColumns(c.Columns); err != nil {
+		return err
+	}
+	if c.Columns != nil && c.OutputFormat != "" && c.OutputFormat != FormatCSV {
+		return errors.New("Columns are only supported by the CSV output")
 	}
 
-	return nil
+	return c.Schema.Validate()
 }
@@ -35,6 +35,71 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			hasError: true,
 		},
+		{
+			name: "unknown invalid positions policy - error",
+			config: &Config{
+				MaxSpeed:         100,
+				Concurrency:      2,
+				InvalidPositions: 3,
+			},
+			hasError: true,
+		},
+		{
+			name: "unknown output column - error",
+			config: &Config{
+				MaxSpeed:    100,
+				Concurrency: 2,
+				Columns:     []string{"id_ride", "speed"},
+			},
+			hasError: true,
+		},
+		{
+			name: "output columns of ndjson - error",
+			config: &Config{
+				MaxSpeed:     100,
+				Concurrency:  2,
+				OutputFormat: FormatNDJSON,
+				Columns:      []string{"id_ride", "fare"},
+			},
+			hasError: true,
+		},
+		{
+			name: "negative order window - error",
+			config: &Config{
+				MaxSpeed:    100,
+				Concurrency: 2,
+				OrderWindow: -1,
+			},
+			hasError: true,
+		},
+		{
+			name: "unknown output format - error",
+			config: &Config{
+				MaxSpeed:     100,
+				Concurrency:  2,
+				OutputFormat: "xml",
+			},
+			hasError: true,
+		},
+		{
+			name: "unknown error mode - error",
+			config: &Config{
+				MaxSpeed:    100,
+				Concurrency: 2,
+				ErrorPolicy: ErrorPolicy{Mode: 7},
+			},
+			hasError: true,
+		},
+		{
+			name: "partitioned geojson - error",
+			config: &Config{
+				MaxSpeed:     100,
+				Concurrency:  2,
+				OutputFormat: FormatGeoJSON,
+				PartitionDir: "out",
+			},
+			hasError: true,
+		},
 	}
 
 	for _, test := range tests {
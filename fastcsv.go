@@ -0,0 +1,153 @@
+package fare
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"unsafe"
+)
+
+// fastCSVBufferSize is the buffer size of the fastCSVReader, which is the maximum length of a line
+const fastCSVBufferSize = 64 * 1024
+
+// fastCSVReader is a source which scans the plain four-column CSV of ride id, lat, lng and timestamp
+// byte by byte and parses the valid lines straight into positions, without allocating their lines.
+// it supports neither quotes nor the other layouts of the Schema. The lines which are not valid positions,
+// e.g. RFC 3339 timestamps, are returned as lines to be parsed and rejected like the lines of the csvReader
+type fastCSVReader struct {
+	reader *bufio.Reader
+	format TimestampFormat
+	number int
+	offset int64
+}
+
+// newFastCSVReader creates a fastCSVReader
+func newFastCSVReader(r io.Reader, format TimestampFormat) *fastCSVReader {
+	return &fastCSVReader{
+		reader: bufio.NewReaderSize(r, fastCSVBufferSize),
+		format: format,
+	}
+}
+
+// read reads the next line of the input, empty lines are skipped like by encoding/csv
+// the lines which are not four columns without quotes are returned as malformed records
+func (f *fastCSVReader) read() (record, error) {
+	for {
+		data, err := f.reader.ReadSlice('\n')
+		switch {
+		case err == bufio.ErrBufferFull:
+			return f.skipLong(data)
+		case err == io.EOF && len(data) == 0:
+			return record{}, io.EOF
+		case err != nil && err != io.EOF:
+			return record{}, err
+		}
+		f.number++
+		f.offset += int64(len(data))
+
+		data = bytes.TrimSuffix(data, []byte{'\n'})
+		data = bytes.TrimSuffix(data, []byte{'\r'})
+		if len(data) > 0 {
+			return f.parse(data)
+		}
+	}
+}
+
+// skipLong skips the rest of a line which is longer than the buffer and returns it as a malformed record
+func (f *fastCSVReader) skipLong(data []byte) (record, error) {
+	f.number++
+	f.offset += int64(len(data))
+	for {
+		data, err := f.reader.ReadSlice('\n')
+		f.offset += int64(len(data))
+		switch {
+		case err == bufio.ErrBufferFull:
+		case err == nil || err == io.EOF:
+			return f.malformed(nil, fmt.Errorf("line is longer than %d bytes", fastCSVBufferSize)), nil
+		default:
+			return record{}, err
+		}
+	}
+}
+
+// malformed returns the record of a malformed line with its first four fields
+func (f *fastCSVReader) malformed(data []byte, err error) record {
+	line := make(Line, 4)
+	for i, field := range bytes.SplitN(data, []byte{','}, 5) {
+		if i < len(line) {
+			line[i] = string(field)
+		}
+	}
+	return record{number: f.number, line: line, offset: f.offset, malformed: err}
+}
+
+// parse parses a line into a position, or into a line if it is not a valid position
+func (f *fastCSVReader) parse(data []byte) (record, error) {
+	if columns := bytes.Count(data, []byte{','}) + 1; columns != 4 {
+		return f.malformed(data, fmt.Errorf("expected 4 columns, got %d", columns)), nil
+	}
+	if bytes.IndexByte(data, '"') >= 0 {
+		return f.malformed(data, errors.New("quoted fields are not supported")), nil
+	}
+
+	var fields [4][]byte
+	for i := 0; i < 3; i++ {
+		comma := bytes.IndexByte(data, ',')
+		fields[i], data = data[:comma], data[comma+1:]
+	}
+	fields[3] = data
+
+	if position, ok := f.position(fields); ok {
+		return record{number: f.number, position: position, offset: f.offset}, nil
+	}
+
+	line := Line{string(fields[0]), string(fields[1]), string(fields[2]), string(fields[3])}
+	return record{number: f.number, line: line, offset: f.offset}, nil
+}
+
+// position parses the fields into a position, it tells false if any of them is not valid
+// the fields are parsed in place, so they must not be kept after it returns
+func (f *fastCSVReader) position(fields [4][]byte) (Position, bool) {
+	rideID, err := strconv.Atoi(unsafeString(fields[0]))
+	if err != nil {
+		return Position{}, false
+	}
+	lat, err := strconv.ParseFloat(unsafeString(fields[1]), 64)
+	if err != nil || !validLatitude(lat) {
+		return Position{}, false
+	}
+	long, err := strconv.ParseFloat(unsafeString(fields[2]), 64)
+	if err != nil || !validLongitude(long) {
+		return Position{}, false
+	}
+
+	raw := unsafeString(fields[3])
+	format := f.format
+	if format == TimestampAuto {
+		format = detectTimestampFormat(raw)
+	}
+	if format == TimestampRFC3339 {
+		// left to newPosition, time.Parse may keep parts of its input
+		return Position{}, false
+	}
+	timestamp, err := ParseTimestamp(raw, format)
+	if err != nil || !validTimestamp(timestamp) {
+		return Position{}, false
+	}
+
+	return Position{
+		RideID:    rideID,
+		Lat:       lat,
+		Long:      long,
+		Timestamp: timestamp,
+	}, true
+}
+
+// unsafeString returns the bytes as a string without copying them
+// the string is only valid as long as the bytes are not modified
+func unsafeString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
@@ -0,0 +1,112 @@
+package fare
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFastCSVReader_read(t *testing.T) {
+	position := func(rideID int, lat, long float64, timestamp time.Time) Position {
+		return Position{RideID: rideID, Lat: lat, Long: long, Timestamp: timestamp}
+	}
+	tests := []struct {
+		name     string
+		input    string
+		format   TimestampFormat
+		records  []record
+		hasError bool
+	}{
+		{
+			name:  "positions",
+			input: "1,37.966660,23.728308,1405594957\r\n\n1,37.966627,23.728263,1405594966123",
+			records: []record{
+				{number: 1, position: position(1, 37.966660, 23.728308, time.Unix(1405594957, 0)), offset: 34},
+				{number: 3, position: position(1, 37.966627, 23.728263, time.Unix(1405594966, 123e6)), offset: 70},
+			},
+		},
+		{
+			name:   "timestamp format",
+			input:  "1,37.966660,23.728308,1405594957\n",
+			format: TimestampMillis,
+			records: []record{
+				{number: 1, line: Line{"1", "37.966660", "23.728308", "1405594957"}, offset: 33},
+			},
+		},
+		{
+			name:  "invalid positions as lines",
+			input: "a,37.966660,23.728308,1405594957\n1,97.966660,23.728308,1405594957\n1,37.966660,23.728308,2014-07-17T11:02:37Z\n",
+			records: []record{
+				{number: 1, line: Line{"a", "37.966660", "23.728308", "1405594957"}, offset: 33},
+				{number: 2, line: Line{"1", "97.966660", "23.728308", "1405594957"}, offset: 66},
+				{number: 3, line: Line{"1", "37.966660", "23.728308", "2014-07-17T11:02:37Z"}, offset: 109},
+			},
+		},
+		{
+			name:  "missing columns - malformed",
+			input: "1,37.966660,23.728308,1405594957\n1,37.966660\n",
+			records: []record{
+				{number: 1, position: position(1, 37.966660, 23.728308, time.Unix(1405594957, 0)), offset: 33},
+				{number: 2, line: Line{"1", "37.966660", "", ""}, offset: 45, malformed: errors.New("expected 4 columns, got 2")},
+			},
+		},
+		{
+			name:  "quotes - malformed",
+			input: `"1",37.966660,23.728308,1405594957`,
+			records: []record{
+				{number: 1, line: Line{`"1"`, "37.966660", "23.728308", "1405594957"}, offset: 34, malformed: errors.New("quoted fields are not supported")},
+			},
+		},
+		{
+			name:  "long line - malformed",
+			input: strings.Repeat("1", fastCSVBufferSize) + "\n1,37.966660,23.728308,1405594957\n",
+			records: []record{
+				{number: 1, line: Line{"", "", "", ""}, offset: fastCSVBufferSize + 1, malformed: errors.New("line is longer than 65536 bytes")},
+				{number: 2, position: position(1, 37.966660, 23.728308, time.Unix(1405594957, 0)), offset: fastCSVBufferSize + 34},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := newFastCSVReader(strings.NewReader(test.input), test.format)
+
+			var got []record
+			var err error
+			for {
+				var rec record
+				rec, err = reader.read()
+				if err != nil {
+					break
+				}
+				got = append(got, rec)
+			}
+
+			assert.Equal(t, test.records, got)
+			assert.Equal(t, test.hasError, err != io.EOF)
+		})
+	}
+}
+
+func TestFastCSVReader_read_allocs(t *testing.T) {
+	input := strings.Repeat("1,37.966660,23.728308,1405594957\n", 1000)
+	reader := newFastCSVReader(strings.NewReader(input), TimestampAuto)
+
+	allocs := testing.AllocsPerRun(500, func() {
+		if _, err := reader.read(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func TestRecord_sameRide(t *testing.T) {
+	parsed := record{position: Position{RideID: 1}}
+	assert.True(t, parsed.sameRide(record{position: Position{RideID: 1}}))
+	assert.False(t, parsed.sameRide(record{position: Position{RideID: 2}}))
+	assert.True(t, parsed.sameRide(record{line: Line{"1", "a", "", ""}}))
+	assert.False(t, record{line: Line{"2"}}.sameRide(parsed))
+}
@@ -0,0 +1,90 @@
+package fare
+
+import (
+	"fmt"
+	"io"
+)
+
+// Format is the format of the input or the output
+type Format string
+
+const (
+	// FormatCSV is comma separated values, the default format
+	FormatCSV Format = "csv"
+	// FormatNDJSON is newline delimited JSON, one object per line
+	FormatNDJSON Format = "ndjson"
+	// FormatGPX is GPS Exchange Format, its tracks are rides, it is only an input format
+	FormatGPX Format = "gpx"
+	// FormatGeoJSON is a GeoJSON document, its LineString features are rides with a coordTimes property
+	// for the timestamps. As an output format the fares of the rides are written with their segments
+	// and the quality report is written as CSV
+	FormatGeoJSON Format = "geojson"
+)
+
+// source reads the input one record at a time, it returns io.EOF at the end of the input
+type source interface {
+	read() (record, error)
+}
+
+// sink writes the results of the rides to an output
+type sink interface {
+	write(f rideFare) error
+	// flush writes any buffered data to the output
+	flush() error
+}
+
+// validInputFormat checks if the format can be read
+func validInputFormat(format Format) error {
+	switch format {
+	case "", FormatCSV, FormatNDJSON, FormatGPX, FormatGeoJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown input format %q", format)
+}
+
+// validOutputFormat checks if the format can be written
+func validOutputFormat(format Format) error {
+	switch format {
+	case "", FormatCSV, FormatNDJSON, FormatGeoJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+// newSource creates a source which reads the input of the format
+// the tracks and features of the GPX and GeoJSON inputs without an id are given theirs by ids
+func newSource(r io.Reader, format Format, schema Schema, ids *defaultRideIDs) source {
+	switch format {
+	case FormatNDJSON:
+		return newNDJSONReader(r, schema)
+	case FormatGPX:
+		return newGPXReader(r, ids)
+	case FormatGeoJSON:
+		return newGeoJSONReader(r, ids)
+	default:
+		return newCSVReader(r, schema)
+	}
+}
+
+// newSink creates a sink which writes the fares of the rides in the format
+// the columns are the columns of the CSV output and extra are the names of the extra columns of the input
+func newSink(w io.Writer, format Format, columns, extra []string) sink {
+	switch format {
+	case FormatNDJSON:
+		return newNDJSONSink(w, extra)
+	case FormatGeoJSON:
+		return newGeoJSONSink(w, extra)
+	default:
+		return newCSVSink(w, columns, extra)
+	}
+}
+
+// newQualitySink creates a sink which writes the quality report of the rides in the format
+func newQualitySink(w io.Writer, format Format) sink {
+	switch format {
+	case FormatNDJSON:
+		return newNDJSONQualitySink(w)
+	default:
+		return newCSVQualitySink(w)
+	}
+}
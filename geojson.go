@@ -0,0 +1,287 @@
+package fare
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// geojsonObject is a GeoJSON FeatureCollection or Feature, the fields of both are merged
+type geojsonObject struct {
+	Type       string                     `json:"type"`
+	Features   []geojsonObject            `json:"features"`
+	ID         json.RawMessage            `json:"id"`
+	Geometry   *geojsonGeometry           `json:"geometry"`
+	Properties map[string]json.RawMessage `json:"properties"`
+}
+
+// geojsonGeometry is a LineString or a MultiLineString geometry
+type geojsonGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// geojsonReader reads the positions of the LineString and MultiLineString features of a GeoJSON document,
+// the timestamps of the positions are the coordTimes property of the feature. Each feature is a ride,
+// the id of the ride is the id_ride property or the id of the feature, otherwise the next id of ids,
+// which is given after the ids of all features of the document are taken
+type geojsonReader struct {
+	reader io.Reader
+	ids    *defaultRideIDs
+	// lines are the positions of all features, which are read at once
+	lines []Line
+}
+
+// newGeoJSONReader creates a geojsonReader
+func newGeoJSONReader(r io.Reader, ids *defaultRideIDs) *geojsonReader {
+	return &geojsonReader{reader: r, ids: ids}
+}
+
+// read reads the next position of the GeoJSON document
+// the input has no meaningful line numbers, so they are 0
+func (g *geojsonReader) read() (record, error) {
+	if g.reader != nil {
+		err := g.decode()
+		g.reader = nil
+		if err != nil {
+			return record{}, err
+		}
+	}
+
+	if len(g.lines) == 0 {
+		return record{}, io.EOF
+	}
+	line := g.lines[0]
+	g.lines = g.lines[1:]
+	return record{line: line}, nil
+}
+
+// decode decodes the GeoJSON document into the lines of positions
+func (g *geojsonReader) decode() error {
+	var object geojsonObject
+	if err := json.NewDecoder(g.reader).Decode(&object); err != nil {
+		return fmt.Errorf("geojson: %w", err)
+	}
+
+	features := []geojsonObject{object}
+	if object.Type == "FeatureCollection" {
+		features = object.Features
+	}
+
+	// the ids of the features are taken before the features without an id are given theirs
+	ids := make([]string, len(features))
+	for i, feature := range features {
+		if feature.Geometry == nil {
+			continue
+		}
+		id, err := feature.id()
+		if err == nil && id != "" {
+			err = g.ids.take(id)
+		}
+		if err != nil {
+			return fmt.Errorf("geojson feature %d: %w", i+1, err)
+		}
+		ids[i] = id
+	}
+
+	for i, feature := range features {
+		if feature.Geometry == nil {
+			continue
+		}
+		if ids[i] == "" {
+			ids[i] = g.ids.next()
+		}
+		lines, err := feature.lines(ids[i])
+		if err != nil {
+			return fmt.Errorf("geojson feature %d: %w", i+1, err)
+		}
+		g.lines = append(g.lines, lines...)
+	}
+
+	return nil
+}
+
+// id returns the id_ride property or the id of the feature, empty if it has none
+func (f geojsonObject) id() (string, error) {
+	if raw, ok := f.Properties["id_ride"]; ok {
+		id, err := jsonValue(raw)
+		if err != nil {
+			return "", fmt.Errorf("id_ride %w", err)
+		}
+		return id, nil
+	}
+	if len(f.ID) > 0 {
+		id, err := jsonValue(f.ID)
+		if err != nil {
+			return "", fmt.Errorf("id %w", err)
+		}
+		return id, nil
+	}
+	return "", nil
+}
+
+// lines returns the positions of the feature as lines of the ride id
+func (f geojsonObject) lines(rideID string) ([]Line, error) {
+	var coordinates [][]json.Number
+	var times []json.RawMessage
+	switch f.Geometry.Type {
+	case "LineString":
+		if err := json.Unmarshal(f.Geometry.Coordinates, &coordinates); err != nil {
+			return nil, fmt.Errorf("coordinates: %w", err)
+		}
+		if err := unmarshalProperty(f.Properties, "coordTimes", &times); err != nil {
+			return nil, err
+		}
+	case "MultiLineString":
+		var multiCoordinates [][][]json.Number
+		var multiTimes [][]json.RawMessage
+		if err := json.Unmarshal(f.Geometry.Coordinates, &multiCoordinates); err != nil {
+			return nil, fmt.Errorf("coordinates: %w", err)
+		}
+		if err := unmarshalProperty(f.Properties, "coordTimes", &multiTimes); err != nil {
+			return nil, err
+		}
+		if len(multiTimes) != len(multiCoordinates) {
+			return nil, errors.New("coordTimes should have the same length as coordinates")
+		}
+		for i := range multiCoordinates {
+			coordinates = append(coordinates, multiCoordinates[i]...)
+			times = append(times, multiTimes[i]...)
+		}
+	default:
+		// only line geometries are tracks
+		return nil, nil
+	}
+
+	if len(times) != len(coordinates) {
+		return nil, errors.New("coordTimes should have the same length as coordinates")
+	}
+
+	lines := make([]Line, 0, len(coordinates))
+	for i, position := range coordinates {
+		if len(position) < 2 {
+			return nil, fmt.Errorf("position %d should have at least two coordinates", i)
+		}
+		timestamp, err := jsonValue(times[i])
+		if err != nil {
+			return nil, fmt.Errorf("coordTimes %d %w", i, err)
+		}
+		lines = append(lines, Line{rideID, position[1].String(), position[0].String(), timestamp})
+	}
+	return lines, nil
+}
+
+// unmarshalProperty unmarshals a required property of a feature
+func unmarshalProperty(properties map[string]json.RawMessage, name string, v interface{}) error {
+	raw, ok := properties[name]
+	if !ok {
+		return fmt.Errorf("property %q is missing", name)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("property %q: %w", name, err)
+	}
+	return nil
+}
+
+// geojsonFeature is the GeoJSON output of the fare of a ride, a LineString feature of its accepted positions
+type geojsonFeature struct {
+	Type       string                `json:"type"`
+	Geometry   *geojsonLineString    `json:"geometry"`
+	Properties geojsonFareProperties `json:"properties"`
+}
+
+// geojsonLineString is a LineString geometry, the coordinates are longitude and latitude pairs
+type geojsonLineString struct {
+	Type        string       `json:"type"`
+	Coordinates [][2]float64 `json:"coordinates"`
+}
+
+// geojsonFareProperties are the properties of a ride feature, coordTimes are the timestamps of the coordinates
+// and segments are the segments between each two consecutive coordinates, extra are the values of the extra columns
+type geojsonFareProperties struct {
+	RideID     int               `json:"id_ride"`
+	Trip       int               `json:"trip,omitempty"`
+	Fare       json.Number       `json:"fare"`
+	CoordTimes []string          `json:"coordTimes"`
+	Segments   []geojsonSegment  `json:"segments"`
+	Extra      map[string]string `json:"extra,omitempty"`
+}
+
+// geojsonSegment is the pricing of a segment, its speed is in km/h
+type geojsonSegment struct {
+	Speed json.Number `json:"speed"`
+	Band  string      `json:"band"`
+	Fare  json.Number `json:"fare"`
+}
+
+// geojsonSink writes the fare of rides as the features of a GeoJSON FeatureCollection, see geojsonFeature
+// a trip without segments has no geometry
+type geojsonSink struct {
+	writer *bufio.Writer
+	count  int
+	// extra are the names of the extra columns
+	extra []string
+}
+
+// newGeoJSONSink creates a geojsonSink of the extra columns
+func newGeoJSONSink(w io.Writer, extra []string) *geojsonSink {
+	return &geojsonSink{writer: bufio.NewWriter(w), extra: extra}
+}
+
+func (s *geojsonSink) write(f rideFare) error {
+	properties := geojsonFareProperties{
+		RideID:     f.rideId,
+		Trip:       f.trip,
+		Fare:       json.Number(formatFloat(float64(f.fare), 2)),
+		CoordTimes: make([]string, 0, len(f.segments)+1),
+		Segments:   make([]geojsonSegment, 0, len(f.segments)),
+		Extra:      extraValues(s.extra, f),
+	}
+	var geometry *geojsonLineString
+	if len(f.segments) > 0 {
+		geometry = &geojsonLineString{Type: "LineString"}
+		add := func(p Position) {
+			geometry.Coordinates = append(geometry.Coordinates, [2]float64{p.Long, p.Lat})
+			properties.CoordTimes = append(properties.CoordTimes, formatTime(p.Timestamp))
+		}
+		add(f.segments[0].from)
+		for _, seg := range f.segments {
+			add(seg.to)
+			properties.Segments = append(properties.Segments, geojsonSegment{
+				Speed: json.Number(formatFloat(seg.speed, 2)),
+				Band:  seg.band(),
+				Fare:  json.Number(formatFloat(float64(seg.Fare()), 4)),
+			})
+		}
+	}
+
+	data, err := json.Marshal(geojsonFeature{Type: "Feature", Geometry: geometry, Properties: properties})
+	if err != nil {
+		return err
+	}
+
+	separator := ","
+	if s.count == 0 {
+		separator = `{"type":"FeatureCollection","features":[`
+	}
+	s.count++
+	if _, err := s.writer.WriteString(separator + "\n"); err != nil {
+		return err
+	}
+	_, err = s.writer.Write(data)
+	return err
+}
+
+// flush ends the FeatureCollection, nothing is written after it
+func (s *geojsonSink) flush() error {
+	end := "\n]}\n"
+	if s.count == 0 {
+		end = `{"type":"FeatureCollection","features":[]}` + "\n"
+	}
+	if _, err := s.writer.WriteString(end); err != nil {
+		return err
+	}
+	return s.writer.Flush()
+}
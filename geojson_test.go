@@ -0,0 +1,143 @@
+package fare
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeoJSONReader_read(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		lines []Line
+		err   string
+	}{
+		{
+			name: "feature collection",
+			data: `{"type":"FeatureCollection","features":[
+				{"type":"Feature","id":5,"geometry":{"type":"LineString","coordinates":[[23.728308,37.966660,90],[23.728263,37.966627]]},
+				 "properties":{"coordTimes":["2014-07-17T11:02:37Z","2014-07-17T11:02:46Z"]}},
+				{"type":"Feature","geometry":{"type":"Point","coordinates":[23.728308,37.966660]},"properties":{}},
+				{"type":"Feature","geometry":{"type":"MultiLineString","coordinates":[[[23.728308,37.966660]],[[23.728263,37.966627]]]},
+				 "properties":{"id_ride":"8","coordTimes":[[1405594957],[1405594966]]}},
+				{"type":"Feature","geometry":{"type":"LineString","coordinates":[[23.728308,37.966660]]},
+				 "properties":{"coordTimes":[1405594957]}}
+			]}`,
+			lines: []Line{
+				{"5", "37.966660", "23.728308", "2014-07-17T11:02:37Z"},
+				{"5", "37.966627", "23.728263", "2014-07-17T11:02:46Z"},
+				{"8", "37.966660", "23.728308", "1405594957"},
+				{"8", "37.966627", "23.728263", "1405594966"},
+				{"2", "37.966660", "23.728308", "1405594957"},
+			},
+		},
+		{
+			name: "default ids skip the ids of the features",
+			data: `{"type":"FeatureCollection","features":[
+				{"type":"Feature","geometry":{"type":"LineString","coordinates":[[23.728308,37.966660]]},
+				 "properties":{"coordTimes":[1405594957]}},
+				{"type":"Feature","id":1,"geometry":{"type":"LineString","coordinates":[[23.728263,37.966627]]},
+				 "properties":{"coordTimes":[1405594966]}}
+			]}`,
+			lines: []Line{
+				{"2", "37.966660", "23.728308", "1405594957"},
+				{"1", "37.966627", "23.728263", "1405594966"},
+			},
+		},
+		{
+			name: "single feature",
+			data: `{"type":"Feature","geometry":{"type":"LineString","coordinates":[[23.728308,37.966660]]},
+				"properties":{"coordTimes":["2014-07-17T11:02:37Z"]}}`,
+			lines: []Line{
+				{"1", "37.966660", "23.728308", "2014-07-17T11:02:37Z"},
+			},
+		},
+		{
+			name: "missing coordTimes - error",
+			data: `{"type":"Feature","geometry":{"type":"LineString","coordinates":[[23.728308,37.966660]]},"properties":{}}`,
+			err:  `geojson feature 1: property "coordTimes" is missing`,
+		},
+		{
+			name: "coordTimes length mismatch - error",
+			data: `{"type":"Feature","geometry":{"type":"LineString","coordinates":[[23.728308,37.966660]]},
+				"properties":{"coordTimes":[]}}`,
+			err: `geojson feature 1: coordTimes should have the same length as coordinates`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			in := newGeoJSONReader(strings.NewReader(test.data), &defaultRideIDs{})
+			var lines []Line
+			var err error
+			for {
+				var rec record
+				rec, err = in.read()
+				if err != nil {
+					break
+				}
+				lines = append(lines, rec.line)
+			}
+			assert.Equal(t, test.lines, lines)
+			if test.err == "" {
+				assert.Equal(t, io.EOF, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestGeoJSONSink_write(t *testing.T) {
+	// the positions are in UTC, as the bands of the segments are by the hour of their time zone
+	p1 := Position{RideID: 1, Lat: 37.96666, Long: 23.728308, Timestamp: time.Unix(1405594957, 0).UTC()}
+	p2 := Position{RideID: 1, Lat: 37.966627, Long: 23.728263, Timestamp: time.Unix(1405594966, 0).UTC()}
+	p3 := Position{RideID: 1, Lat: 37.976627, Long: 23.728263, Timestamp: time.Unix(1405595026, 0).UTC()}
+	seg1, err := NewSegment(p1, p2, 100, nil)
+	assert.Nil(t, err)
+	seg2, err := NewSegment(p2, p3, 100, nil)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name   string
+		fares  []rideFare
+		output string
+	}{
+		{
+			name:   "no rides",
+			output: `{"type":"FeatureCollection","features":[]}` + "\n",
+		},
+		{
+			name: "rides with and without segments",
+			fares: []rideFare{
+				{rideId: 1, trip: 1, fare: 3.47, segments: []Segment{seg1, seg2}},
+				{rideId: 2, fare: 3.47},
+			},
+			output: `{"type":"FeatureCollection","features":[
+{"type":"Feature","geometry":{"type":"LineString","coordinates":[[23.728308,37.96666],[23.728263,37.966627],[23.728263,37.976627]]},"properties":{"id_ride":1,"trip":1,"fare":3.47,"coordTimes":["2014-07-17T11:02:37Z","2014-07-17T11:02:46Z","2014-07-17T11:03:46Z"],"segments":[{"speed":2.16,"band":"idle","fare":0.0298},{"speed":66.72,"band":"normal","fare":0.8228}]}},
+{"type":"Feature","geometry":null,"properties":{"id_ride":2,"fare":3.47,"coordTimes":[],"segments":[]}}
+]}
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			s := newGeoJSONSink(out, nil)
+			for _, f := range test.fares {
+				assert.Nil(t, s.write(f))
+			}
+			assert.Nil(t, s.flush())
+			assert.Equal(t, test.output, out.String())
+
+			// the output is a valid GeoJSON document which can be read back
+			assert.True(t, json.Valid(out.Bytes()))
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package fare
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// gpxPoint is a track point of a GPX file
+type gpxPoint struct {
+	Lat  string `xml:"lat,attr"`
+	Lon  string `xml:"lon,attr"`
+	Time string `xml:"time"`
+}
+
+// gpxReader reads the track points of a GPX file, each track is a ride
+// the id of the ride is the number of the track if it has one, otherwise the next id of ids,
+// which is given when the first point of the track is read, after its number
+type gpxReader struct {
+	decoder *xml.Decoder
+	ids     *defaultRideIDs
+	rideID  string
+	// inTrack tells the decoder is inside a track
+	inTrack bool
+}
+
+// newGPXReader creates a gpxReader
+func newGPXReader(r io.Reader, ids *defaultRideIDs) *gpxReader {
+	return &gpxReader{decoder: xml.NewDecoder(r), ids: ids}
+}
+
+// read reads the next track point of the GPX file
+// errors name the line number of the input which caused them
+func (g *gpxReader) read() (record, error) {
+	for {
+		token, err := g.decoder.Token()
+		if err == io.EOF {
+			return record{}, io.EOF
+		}
+		if err != nil {
+			return record{}, g.errorf("%w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Local == "trk":
+				g.rideID = ""
+				g.inTrack = true
+			case t.Name.Local == "number" && g.inTrack && g.rideID == "":
+				var number string
+				if err := g.decoder.DecodeElement(&number, &t); err != nil {
+					return record{}, g.errorf("track number: %w", err)
+				}
+				number = strings.TrimSpace(number)
+				if _, err := strconv.Atoi(number); err == nil {
+					if err := g.ids.take(number); err != nil {
+						return record{}, g.errorf("track number: %w", err)
+					}
+					g.rideID = number
+				}
+			case t.Name.Local == "trkpt" && g.inTrack:
+				line, _ := g.decoder.InputPos()
+				var point gpxPoint
+				if err := g.decoder.DecodeElement(&point, &t); err != nil {
+					return record{}, g.errorf("track point: %w", err)
+				}
+				if g.rideID == "" {
+					g.rideID = g.ids.next()
+				}
+				return record{
+					number: line,
+					line:   Line{g.rideID, point.Lat, point.Lon, strings.TrimSpace(point.Time)},
+				}, nil
+			}
+		case xml.EndElement:
+			if t.Name.Local == "trk" {
+				g.inTrack = false
+			}
+		}
+	}
+}
+
+// errorf formats an error prefixed by the current line number
+func (g *gpxReader) errorf(format string, args ...interface{}) error {
+	line, _ := g.decoder.InputPos()
+	return fmt.Errorf("line %d: "+format, append([]interface{}{line}, args...)...)
+}
@@ -0,0 +1,118 @@
+package fare
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGPXReader_read(t *testing.T) {
+	data := `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="field tester" xmlns="http://www.topografix.com/GPX/1/1">
+  <wpt lat="37.0" lon="23.0"><time>2014-07-17T11:00:00Z</time></wpt>
+  <trk>
+    <name>morning ride</name>
+    <number>7</number>
+    <trkseg>
+      <trkpt lat="37.966660" lon="23.728308"><ele>90</ele><time>2014-07-17T11:02:37Z</time></trkpt>
+      <trkpt lat="37.966627" lon="23.728263"><time>2014-07-17T11:02:46Z</time></trkpt>
+    </trkseg>
+  </trk>
+  <trk>
+    <trkseg>
+      <trkpt lat="37.966660" lon="23.728308"><time>2014-07-17T11:02:37Z</time></trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+	in := newGPXReader(strings.NewReader(data), &defaultRideIDs{})
+	var records []record
+	var err error
+	for {
+		var rec record
+		rec, err = in.read()
+		if err != nil {
+			break
+		}
+		records = append(records, rec)
+	}
+
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []record{
+		{number: 8, line: Line{"7", "37.966660", "23.728308", "2014-07-17T11:02:37Z"}},
+		{number: 9, line: Line{"7", "37.966627", "23.728263", "2014-07-17T11:02:46Z"}},
+		{number: 14, line: Line{"1", "37.966660", "23.728308", "2014-07-17T11:02:37Z"}},
+	}, records)
+}
+
+func TestGPXReader_read_malformed(t *testing.T) {
+	data := `<gpx>
+  <trk><trkseg>
+    <trkpt lat="37.966660" lon="23.728308"><time>2014-07-17T11:02:37Z</time>
+  </trkseg></trk>
+</gpx>`
+
+	in := newGPXReader(strings.NewReader(data), &defaultRideIDs{})
+	_, err := in.read()
+	assert.EqualError(t, err, "line 4: track point: XML syntax error on line 4: element <trkpt> closed by </trkseg>")
+}
+
+func TestGPXReader_read_inputs(t *testing.T) {
+	track := func(number string) string {
+		if number != "" {
+			number = "<number>" + number + "</number>"
+		}
+		return `<trk>` + number + `<trkseg><trkpt lat="37.966660" lon="23.728308"><time>2014-07-17T11:02:37Z</time></trkpt></trkseg></trk>`
+	}
+
+	tests := []struct {
+		name string
+		// inputs are the GPX files which are read one after another
+		inputs []string
+		ids    []string
+		err    string
+	}{
+		{
+			name:   "tracks without a number are counted across the inputs",
+			inputs: []string{"<gpx>" + track("") + "</gpx>", "<gpx>" + track("") + "</gpx>"},
+			ids:    []string{"1", "2"},
+		},
+		{
+			name:   "numbers of the tracks are skipped",
+			inputs: []string{"<gpx>" + track("") + track("2") + track("") + "</gpx>"},
+			ids:    []string{"1", "2", "3"},
+		},
+		{
+			name:   "number given to a track without a number - error",
+			inputs: []string{"<gpx>" + track("") + "</gpx>", "<gpx>" + track("1") + "</gpx>"},
+			ids:    []string{"1"},
+			err:    "line 1: track number: id 1 is already given to a ride without an id",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ids := &defaultRideIDs{}
+			var rideIDs []string
+			var err error
+			for _, input := range test.inputs {
+				in := newGPXReader(strings.NewReader(input), ids)
+				var rec record
+				for rec, err = in.read(); err == nil; rec, err = in.read() {
+					rideIDs = append(rideIDs, rec.line[0])
+				}
+				if err != io.EOF {
+					break
+				}
+			}
+			assert.Equal(t, test.ids, rideIDs)
+			if test.err == "" {
+				assert.Equal(t, io.EOF, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package fare
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// multiSource reads the inputs one after another as a single source, so a ride which continues
+// from the end of an input to the start of the next one is not split
+// each input is decompressed and read by its own source, so each one can have its own header
+type multiSource struct {
+	inputs    []io.Reader
+	newSource func(io.Reader) (source, error)
+	current   source
+	// name is the name of the current input which prefixes its errors
+	name string
+}
+
+// read reads the next record of the current input, moving to the next input at the end of it
+func (m *multiSource) read() (record, error) {
+	for {
+		if m.current == nil {
+			if len(m.inputs) == 0 {
+				return record{}, io.EOF
+			}
+			input := m.inputs[0]
+			m.inputs = m.inputs[1:]
+			m.name = inputName(input)
+			current, err := m.newSource(input)
+			if err != nil {
+				return record{}, fmt.Errorf("%s: %w", m.name, err)
+			}
+			m.current = current
+		}
+
+		rec, err := m.current.read()
+		switch {
+		case err == io.EOF:
+			m.current = nil
+		case err != nil:
+			return record{}, fmt.Errorf("%s: %w", m.name, err)
+		default:
+			return rec, nil
+		}
+	}
+}
+
+// defaultRideIDs gives ids to the tracks and features of the GPX and GeoJSON inputs which have no id, counting
+// from 1 and skipping the ids which are taken by the other tracks and features. It is shared by all inputs of a run,
+// so a ride without an id does not get the id of another ride of any input and the two are not merged
+type defaultRideIDs struct {
+	last int
+	// taken are the ids of the tracks and features which have one, given are the default ids
+	taken, given map[string]bool
+}
+
+// next returns the next id which is not taken
+func (d *defaultRideIDs) next() string {
+	for {
+		d.last++
+		id := strconv.Itoa(d.last)
+		if d.taken[id] {
+			continue
+		}
+		if d.given == nil {
+			d.given = make(map[string]bool)
+		}
+		d.given[id] = true
+		return id
+	}
+}
+
+// take takes the id of a track or feature, so it is not given to the ones without an id,
+// it fails if the id is already given to one of them
+func (d *defaultRideIDs) take(id string) error {
+	if d.given[id] {
+		return fmt.Errorf("id %s is already given to a ride without an id", id)
+	}
+	if d.taken == nil {
+		d.taken = make(map[string]bool)
+	}
+	d.taken[id] = true
+	return nil
+}
+
+// inputName returns the name of an input, which is the name of the file for files
+func inputName(input io.Reader) string {
+	if named, ok := input.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return "input"
+}
@@ -0,0 +1,88 @@
+package fare
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiSource_read(t *testing.T) {
+	tests := []struct {
+		name    string
+		inputs  []io.Reader
+		records []record
+		err     string
+	}{
+		{
+			name:   "inputs in order",
+			inputs: []io.Reader{strings.NewReader("1,2,3,4\n1,2,3,5"), strings.NewReader(""), strings.NewReader("2,2,3,4")},
+			records: []record{
+				{number: 1, line: Line{"1", "2", "3", "4"}, offset: 8},
+				{number: 2, line: Line{"1", "2", "3", "5"}, offset: 15},
+				{number: 1, line: Line{"2", "2", "3", "4"}, offset: 7},
+			},
+		},
+		{
+			name:   "malformed line of an input",
+			inputs: []io.Reader{strings.NewReader("1,2,3,4"), strings.NewReader("1,2")},
+			records: []record{
+				{number: 1, line: Line{"1", "2", "3", "4"}, offset: 7},
+				{number: 1, line: Line{"1", "2", "", ""}, offset: 3, malformed: csv.ErrFieldCount},
+			},
+		},
+		{
+			name:    "error of an input is prefixed by its name",
+			inputs:  []io.Reader{strings.NewReader("1,2,3,4"), failingInput{name: "rides.csv"}},
+			records: []record{{number: 1, line: Line{"1", "2", "3", "4"}, offset: 7}},
+			err:     "rides.csv: disk failure",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &multiSource{inputs: test.inputs, newSource: func(r io.Reader) (source, error) {
+				return newCSVReader(r, Schema{}), nil
+			}}
+
+			var got []record
+			var err error
+			for {
+				var rec record
+				rec, err = m.read()
+				if err != nil {
+					break
+				}
+				got = append(got, rec)
+			}
+
+			assert.Equal(t, test.records, got)
+			if test.err == "" {
+				assert.Equal(t, io.EOF, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
+
+// failingInput is a named input which fails to be read
+type failingInput struct {
+	name string
+}
+
+func (f failingInput) Name() string {
+	return f.name
+}
+
+func (f failingInput) Read([]byte) (int, error) {
+	return 0, errors.New("disk failure")
+}
+
+func TestInputName(t *testing.T) {
+	assert.Equal(t, "input", inputName(strings.NewReader("")))
+	assert.Equal(t, "/dev/stdin", inputName(os.Stdin))
+}
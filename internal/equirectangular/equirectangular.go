@@ -0,0 +1,18 @@
+package equirectangular
+
+import "math"
+
+const earthRadius = float64(6371)
+
+// Equirectangular calculates the distance in kilometers between two points by projecting them
+// on a plane (equirectangular approximation). It is cheaper than haversine and accurate enough
+// for short distances such as the segments of a ride, but the error grows with the distance.
+func Equirectangular(lonFrom float64, latFrom float64, lonTo float64, latTo float64) float64 {
+	var deltaLat = (latTo - latFrom) * (math.Pi / 180)
+	var deltaLon = (lonTo - lonFrom) * (math.Pi / 180)
+	var meanLat = (latFrom + latTo) / 2 * (math.Pi / 180)
+
+	var x = deltaLon * math.Cos(meanLat)
+
+	return earthRadius * math.Sqrt(x*x+deltaLat*deltaLat)
+}
@@ -0,0 +1,39 @@
+package equirectangular
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEquirectangular(t *testing.T) {
+	tests := []struct {
+		name     string
+		latlangs []float64
+		distance float64
+	}{
+		{
+			name:     "ok",
+			latlangs: []float64{23.730235, 37.967349, 23.730235, 37.967348},
+			distance: 0.00011119492664455873,
+		},
+		{
+			name:     "10 degrees on the equator",
+			latlangs: []float64{0, 0, 10, 0},
+			distance: 1111.9492664455872,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			distance := Equirectangular(test.latlangs[0], test.latlangs[1], test.latlangs[2], test.latlangs[3])
+			assert.InDelta(t, test.distance, distance, 1e-12)
+		})
+	}
+}
+
+func BenchmarkEquirectangular(b *testing.B) {
+	latlangs := []float64{23.730235, 37.967349, 23.730235, 37.967348}
+	for n := 0; n < b.N; n++ {
+		Equirectangular(latlangs[0], latlangs[1], latlangs[2], latlangs[3])
+	}
+}
@@ -0,0 +1,230 @@
+package extsort
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/csv"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// lessFunc reports whether the record a must sort before the record b
+type lessFunc func(a, b []string) bool
+
+// Sorter sorts records with bounded memory. Records are added to an in-memory chunk, once the chunk is full
+// it is sorted and spilled to a temporary file. At the end the sorted chunks are merged.
+// records which are equal keep the order in which they were added
+type Sorter struct {
+	less      lessFunc
+	chunkSize int
+	dir       string
+	chunk     [][]string
+	files     []*os.File
+}
+
+// New creates a Sorter which keeps at most chunkSize records in memory and spills the rest
+// to temporary files in dir, if dir is empty the default directory for temporary files is used
+func New(less lessFunc, chunkSize int, dir string) (*Sorter, error) {
+	if chunkSize <= 0 {
+		return nil, errors.New("chunk size should be greater than 0")
+	}
+
+	return &Sorter{
+		less:      less,
+		chunkSize: chunkSize,
+		dir:       dir,
+	}, nil
+}
+
+// Add adds a record to the sorter
+func (s *Sorter) Add(record []string) error {
+	s.chunk = append(s.chunk, record)
+	if len(s.chunk) < s.chunkSize {
+		return nil
+	}
+	return s.spill()
+}
+
+// Sort sorts all added records and returns an Iterator over them
+// the Iterator must be closed to remove the temporary files
+func (s *Sorter) Sort() (*Iterator, error) {
+	if len(s.files) == 0 {
+		// everything fit in memory
+		s.sortChunk()
+		it := &Iterator{less: s.less, chunk: s.chunk}
+		s.chunk = nil
+		return it, nil
+	}
+
+	if len(s.chunk) > 0 {
+		if err := s.spill(); err != nil {
+			return nil, err
+		}
+	}
+	it := &Iterator{less: s.less, files: s.files}
+	s.files = nil
+
+	for i, f := range it.files {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			it.Close()
+			return nil, err
+		}
+		c := &cursor{order: i, reader: csv.NewReader(bufio.NewReader(f))}
+		c.reader.FieldsPerRecord = -1
+		if err := c.next(); err != nil {
+			if err == io.EOF {
+				continue
+			}
+			it.Close()
+			return nil, err
+		}
+		it.cursors = append(it.cursors, c)
+	}
+	heap.Init(it)
+
+	return it, nil
+}
+
+// Close removes the temporary files of the records which are not sorted yet
+func (s *Sorter) Close() error {
+	s.chunk = nil
+	err := removeFiles(s.files)
+	s.files = nil
+	return err
+}
+
+// sortChunk sorts the in-memory chunk
+func (s *Sorter) sortChunk() {
+	sort.SliceStable(s.chunk, func(i, j int) bool {
+		return s.less(s.chunk[i], s.chunk[j])
+	})
+}
+
+// spill sorts the in-memory chunk and writes it to a temporary file
+func (s *Sorter) spill() error {
+	s.sortChunk()
+
+	f, err := ioutil.TempFile(s.dir, "fare-sort-")
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files, f)
+
+	buf := bufio.NewWriter(f)
+	w := csv.NewWriter(buf)
+	if err := w.WriteAll(s.chunk); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+
+	s.chunk = s.chunk[:0]
+	return nil
+}
+
+// Iterator iterates over the sorted records by merging the sorted chunks
+type Iterator struct {
+	less    lessFunc
+	chunk   [][]string
+	files   []*os.File
+	cursors []*cursor
+}
+
+// Next returns the next record in order, it returns io.EOF when there is no more record
+func (it *Iterator) Next() ([]string, error) {
+	if it.files == nil {
+		if len(it.chunk) == 0 {
+			return nil, io.EOF
+		}
+		record := it.chunk[0]
+		it.chunk = it.chunk[1:]
+		return record, nil
+	}
+
+	if len(it.cursors) == 0 {
+		return nil, io.EOF
+	}
+	c := it.cursors[0]
+	record := c.record
+	switch err := c.next(); {
+	case err == io.EOF:
+		heap.Pop(it)
+	case err != nil:
+		return nil, err
+	default:
+		heap.Fix(it, 0)
+	}
+
+	return record, nil
+}
+
+// Close removes the temporary files
+func (it *Iterator) Close() error {
+	it.chunk = nil
+	it.cursors = nil
+	err := removeFiles(it.files)
+	it.files = nil
+	return err
+}
+
+// Len, Less, Swap, Push and Pop implement heap.Interface on the cursors of the chunk files
+
+func (it *Iterator) Len() int { return len(it.cursors) }
+
+func (it *Iterator) Less(i, j int) bool {
+	a, b := it.cursors[i], it.cursors[j]
+	switch {
+	case it.less(a.record, b.record):
+		return true
+	case it.less(b.record, a.record):
+		return false
+	default:
+		// equal records come in the order of the chunks they were added to
+		return a.order < b.order
+	}
+}
+
+func (it *Iterator) Swap(i, j int) { it.cursors[i], it.cursors[j] = it.cursors[j], it.cursors[i] }
+
+func (it *Iterator) Push(x interface{}) { it.cursors = append(it.cursors, x.(*cursor)) }
+
+func (it *Iterator) Pop() interface{} {
+	last := it.cursors[len(it.cursors)-1]
+	it.cursors = it.cursors[:len(it.cursors)-1]
+	return last
+}
+
+// cursor holds the current record of a chunk file
+type cursor struct {
+	order  int
+	reader *csv.Reader
+	record []string
+}
+
+// next reads the next record of the chunk file
+func (c *cursor) next() error {
+	record, err := c.reader.Read()
+	if err != nil {
+		return err
+	}
+	c.record = record
+	return nil
+}
+
+// removeFiles closes and removes the files, it returns the first error
+func removeFiles(files []*os.File) error {
+	var first error
+	for _, f := range files {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+		if err := os.Remove(f.Name()); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
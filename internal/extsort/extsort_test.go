@@ -0,0 +1,103 @@
+package extsort
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSorter(t *testing.T) {
+	byFirst := func(a, b []string) bool {
+		return a[0] < b[0]
+	}
+	records := [][]string{
+		{"c", "1"}, {"a", "1"}, {"b", "1"}, {"a", "2"}, {"c", "2"}, {"b", "2"}, {"a", "3"},
+	}
+	sorted := [][]string{
+		{"a", "1"}, {"a", "2"}, {"a", "3"}, {"b", "1"}, {"b", "2"}, {"c", "1"}, {"c", "2"},
+	}
+
+	tests := []struct {
+		name      string
+		chunkSize int
+		files     int
+	}{
+		{
+			name:      "fits in memory",
+			chunkSize: 10,
+			files:     0,
+		},
+		{
+			name:      "spills to disk",
+			chunkSize: 2,
+			files:     4,
+		},
+		{
+			name:      "spills every record",
+			chunkSize: 1,
+			files:     7,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "extsort")
+			assert.Nil(t, err)
+			defer os.RemoveAll(dir)
+
+			s, err := New(byFirst, test.chunkSize, dir)
+			assert.Nil(t, err)
+			for _, record := range records {
+				assert.Nil(t, s.Add(record))
+			}
+
+			it, err := s.Sort()
+			assert.Nil(t, err)
+			files, _ := ioutil.ReadDir(dir)
+			assert.Equal(t, test.files, len(files))
+
+			var got [][]string
+			for {
+				record, err := it.Next()
+				if err == io.EOF {
+					break
+				}
+				assert.Nil(t, err)
+				got = append(got, record)
+			}
+			assert.Equal(t, sorted, got)
+
+			assert.Nil(t, it.Close())
+			files, _ = ioutil.ReadDir(dir)
+			assert.Equal(t, 0, len(files))
+		})
+	}
+}
+
+func TestNew_zeroChunkSize(t *testing.T) {
+	_, err := New(nil, 0, "")
+	assert.NotNil(t, err)
+}
+
+func BenchmarkSorter(b *testing.B) {
+	less := func(a, b []string) bool {
+		return a[0] < b[0]
+	}
+	for n := 0; n < b.N; n++ {
+		s, _ := New(less, 1000, "")
+		for i := 0; i < 10000; i++ {
+			_ = s.Add([]string{strconv.Itoa((i * 7919) % 10000)})
+		}
+		it, _ := s.Sort()
+		for {
+			if _, err := it.Next(); err != nil {
+				break
+			}
+		}
+		_ = it.Close()
+	}
+}
@@ -0,0 +1,166 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// maxCollectedErrors is the number of errors which are kept for the summary
+const maxCollectedErrors = 10
+
+// ErrorMode decides when the errors of the items fail a pipeline
+type ErrorMode int
+
+const (
+	// SkipErrors skips the failed items and collects their errors
+	SkipErrors ErrorMode = iota
+	// FailFast fails the pipeline on the first error
+	FailFast
+	// FailAfter skips the failed items until there are more than MaxErrors errors
+	FailAfter
+	// FailAboveRatio skips the failed items and fails the pipeline at the end
+	// if the ratio of the failed items is above MaxRatio
+	FailAboveRatio
+)
+
+// ErrorPolicy is the policy of the errors of the items of the worker pools, the zero value skips the failed items
+type ErrorPolicy struct {
+	Mode      ErrorMode
+	MaxErrors int
+	MaxRatio  float64
+}
+
+// Validate checks if the policy is known and its limits are in range
+func (p ErrorPolicy) Validate() error {
+	switch {
+	case p.Mode < SkipErrors || p.Mode > FailAboveRatio:
+		return errors.New("unknown error mode")
+	case p.Mode == FailAfter && p.MaxErrors < 0:
+		return errors.New("MaxErrors should not be negative")
+	case p.Mode == FailAboveRatio && (p.MaxRatio < 0 || p.MaxRatio > 1):
+		return errors.New("MaxRatio should be between 0 and 1")
+	}
+	return nil
+}
+
+func (p ErrorPolicy) String() string {
+	switch p.Mode {
+	case FailFast:
+		return "fail-fast"
+	case FailAfter:
+		return fmt.Sprintf("fail-after=%d", p.MaxErrors)
+	case FailAboveRatio:
+		return fmt.Sprintf("fail-above=%g", p.MaxRatio)
+	default:
+		return "skip"
+	}
+}
+
+// ErrorSummary is the summary of the errors of the items of a pipeline
+type ErrorSummary struct {
+	Policy ErrorPolicy
+	// Items and Failed are the number of the items processed and of those which failed
+	Items, Failed int
+	// Errors are the first errors of the failed items
+	Errors []error
+}
+
+func (s *ErrorSummary) Error() string {
+	messages := make([]string, len(s.Errors))
+	for i, err := range s.Errors {
+		messages[i] = err.Error()
+	}
+	more := ""
+	if s.Failed > len(s.Errors) {
+		more = fmt.Sprintf("; and %d more", s.Failed-len(s.Errors))
+	}
+	return fmt.Sprintf("%d of %d items failed (%s): %s%s", s.Failed, s.Items, s.Policy, strings.Join(messages, "; "), more)
+}
+
+// Unwrap returns the first error
+func (s *ErrorSummary) Unwrap() error {
+	if len(s.Errors) == 0 {
+		return nil
+	}
+	return s.Errors[0]
+}
+
+// Errors collects the errors of the items of the worker pools which run with its context, see WithErrors,
+// and cancels the pipeline when the policy fails it
+type Errors struct {
+	mu      sync.Mutex
+	summary ErrorSummary
+	failed  bool
+	cancel  context.CancelFunc
+}
+
+// NewErrors creates an Errors of the policy which calls cancel when the policy fails the pipeline
+func NewErrors(policy ErrorPolicy, cancel context.CancelFunc) *Errors {
+	return &Errors{summary: ErrorSummary{Policy: policy}, cancel: cancel}
+}
+
+// WithErrors returns a context which makes the worker pools created with it collect the errors of their items
+// into errs instead of publishing them to their error channel
+func WithErrors(ctx context.Context, errs *Errors) context.Context {
+	return context.WithValue(ctx, errorsKey, errs)
+}
+
+// errorsOf returns the Errors of the context, nil if it has none
+func errorsOf(ctx context.Context) *Errors {
+	errs, _ := ctx.Value(errorsKey).(*Errors)
+	return errs
+}
+
+// add collects the result of an item, err is nil if it did not fail
+func (e *Errors) add(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.summary.Items++
+	if err == nil {
+		return
+	}
+	e.summary.Failed++
+	if len(e.summary.Errors) < maxCollectedErrors {
+		e.summary.Errors = append(e.summary.Errors, err)
+	}
+
+	policy := e.summary.Policy
+	if !e.failed && (policy.Mode == FailFast || policy.Mode == FailAfter && e.summary.Failed > policy.MaxErrors) {
+		e.failed = true
+		e.cancel()
+	}
+}
+
+// Summary returns the summary of the errors, nil if no item failed
+func (e *Errors) Summary() *ErrorSummary {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.summary.Failed == 0 {
+		return nil
+	}
+	summary := e.summary
+	summary.Errors = append([]error(nil), e.summary.Errors...)
+	return &summary
+}
+
+// Err returns the summary of the errors if the policy failed the pipeline, it should be called
+// when all items are processed to check the ratio of the failed items
+func (e *Errors) Err() error {
+	e.mu.Lock()
+	policy := e.summary.Policy
+	failed := e.failed || policy.Mode == FailAboveRatio && e.summary.Items > 0 &&
+		float64(e.summary.Failed)/float64(e.summary.Items) > policy.MaxRatio
+	e.mu.Unlock()
+	if !failed {
+		return nil
+	}
+	// a nil summary is returned as a nil error, not as a typed nil
+	summary := e.Summary()
+	if summary == nil {
+		return nil
+	}
+	return summary
+}
@@ -0,0 +1,118 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  ErrorPolicy
+		ordered bool
+		// processed is the number of items which are processed at least
+		processed int
+		failed    int
+		err       string
+	}{
+		{
+			name:      "skip",
+			policy:    ErrorPolicy{Mode: SkipErrors},
+			processed: 10,
+			failed:    5,
+		},
+		{
+			name:      "fail fast",
+			policy:    ErrorPolicy{Mode: FailFast},
+			processed: 1,
+			failed:    1,
+			err:       "1 of 1 items failed (fail-fast): item 1",
+		},
+		{
+			name:      "fail after 2 errors",
+			policy:    ErrorPolicy{Mode: FailAfter, MaxErrors: 2},
+			ordered:   true,
+			processed: 5,
+			failed:    3,
+			err:       "3 of 5 items failed (fail-after=2): item 1; item 3; item 5",
+		},
+		{
+			name:      "ratio is not above the max",
+			policy:    ErrorPolicy{Mode: FailAboveRatio, MaxRatio: 0.5},
+			processed: 10,
+			failed:    5,
+		},
+		{
+			name:      "ratio is above the max",
+			policy:    ErrorPolicy{Mode: FailAboveRatio, MaxRatio: 0.4},
+			processed: 10,
+			failed:    5,
+			err:       "5 of 10 items failed (fail-above=0.4): item 1; item 3; item 5; item 7; item 9",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			errs := NewErrors(test.policy, cancel)
+			ctx = WithErrors(ctx, errs)
+
+			// the odd items fail
+			worker := func(ctx context.Context, item int, outc chan<- int) error {
+				if item%2 == 1 {
+					return fmt.Errorf("item %d", item)
+				}
+				return nil
+			}
+			var outc <-chan int
+			var errc <-chan error
+			inc := generateInt(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 0})
+			if test.ordered {
+				outc, errc = OrderedWorkerPool(ctx, 1, 1, inc, worker)
+			} else {
+				outc, errc = WorkerPool(ctx, 1, inc, worker)
+			}
+			for range outc {
+			}
+			// the errors are collected instead of published
+			assert.Nil(t, <-errc)
+
+			summary := errs.Summary()
+			assert.GreaterOrEqual(t, summary.Items, test.processed)
+			assert.Equal(t, test.failed, summary.Failed)
+			if test.err == "" {
+				assert.Nil(t, errs.Err())
+				assert.Nil(t, ctx.Err())
+			} else {
+				assert.EqualError(t, errs.Err(), test.err)
+			}
+		})
+	}
+}
+
+func TestErrorSummary_Error(t *testing.T) {
+	summary := &ErrorSummary{Items: 20, Failed: 12, Errors: []error{assert.AnError}}
+	assert.Equal(t, "12 of 20 items failed (skip): "+assert.AnError.Error()+"; and 11 more", summary.Error())
+	assert.True(t, errors.Is(summary, assert.AnError))
+}
+
+func TestErrorPolicy_Validate(t *testing.T) {
+	assert.Nil(t, ErrorPolicy{}.Validate())
+	assert.Nil(t, ErrorPolicy{Mode: FailAboveRatio, MaxRatio: 0.1}.Validate())
+	assert.NotNil(t, ErrorPolicy{Mode: 7}.Validate())
+	assert.NotNil(t, ErrorPolicy{Mode: FailAfter, MaxErrors: -1}.Validate())
+	assert.NotNil(t, ErrorPolicy{Mode: FailAboveRatio, MaxRatio: 2}.Validate())
+}
+
+func TestErrors_Err_noFailed(t *testing.T) {
+	// a negative ratio fails the pipeline without failed items, which has no summary
+	errs := NewErrors(ErrorPolicy{Mode: FailAboveRatio, MaxRatio: -1}, func() {})
+	errs.add(nil)
+	assert.Nil(t, errs.Summary())
+	assert.True(t, errs.Err() == nil)
+}
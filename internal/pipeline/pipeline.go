@@ -2,34 +2,33 @@ package pipeline
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"sync"
 )
 
-// Event is used as the type for input and output channels
-type Event interface{}
-
 type (
 	// eachFunc is called for each event of the input channel
-	eachFunc func(val interface{}) error
+	eachFunc[T any] func(val T) error
 	// generateFunc is used in Generate to produce values for the output channel
-	generateFunc func() (interface{}, error)
+	// the value is only put to the output channel if ok is true
+	generateFunc[T any] func() (val T, ok bool, err error)
 	// belongFunc checks if an item belongs to a group
-	belongFunc func(item interface{}, group []interface{}) (bool, error)
+	belongFunc[T any] func(item T, group []T) (bool, error)
 	// workerFunc consumes an item of the input channel
 	// and publishes the result to the output channel
-	workerFunc func(ctx context.Context, item interface{}, outc chan<- Event) error
+	workerFunc[I, O any] func(ctx context.Context, item I, outc chan<- O) error
 	// reduceFunc is called on two subsequent events of the input stream
-	// and reduce them to one item to be published to the output channel
-	reduceFunc func(i interface{}, j interface{}) (interface{}, error)
+	// and reduce them to one item to be published to the output channel if ok is true
+	reduceFunc[I, O any] func(i I, j I) (result O, ok bool, err error)
 )
 
-// Generate converts output of a generateFunc to channel of Event
-// the only way to close the output channel is to return an error from the generateFunc
-// if generateFunc returns nil as the value, Generate won't put it to the channel
-func Generate(ctx context.Context, fn generateFunc) (<-chan Event, <-chan error) {
-	outc := make(chan Event)
+// Generate converts output of a generateFunc to channel of events
+// the only way to close the output channel is to return an error from the generateFunc or to cancel the context
+// if generateFunc returns false as ok, Generate won't put the value to the channel
+func Generate[T any](ctx context.Context, fn generateFunc[T]) (<-chan T, <-chan error) {
+	outc := make(chan T)
 	errc := make(chan error, 1)
+	stats := stageOf(ctx, "generate")
 	go func() {
 		defer func() {
 			close(outc)
@@ -38,17 +37,22 @@ func Generate(ctx context.Context, fn generateFunc) (<-chan Event, <-chan error)
 		for {
 			select {
 			case <-ctx.Done():
-				errc <- errors.New("generate canceled")
+				errc <- fmt.Errorf("generate %w", ErrCanceled)
 				return
 			default:
 			}
-			res, err := fn()
+			start := stats.now()
+			res, ok, err := fn()
+			stats.processed(start)
 			switch {
 			case err != nil:
 				errc <- err
 				return
-			case res != nil: // only non nil res is put to out channel
-				outc <- res
+			case ok: // only ok res is put to out channel
+				if !publish(ctx, outc, res, stats) {
+					errc <- fmt.Errorf("generate %w", ErrCanceled)
+					return
+				}
 			}
 		}
 	}()
@@ -57,56 +61,61 @@ func Generate(ctx context.Context, fn generateFunc) (<-chan Event, <-chan error)
 }
 
 // Group is a transformer that groups events by checking against a belongFunc
-func Group(ctx context.Context, inc <-chan Event, belong belongFunc) (<-chan Event, <-chan error) {
-	outc := make(chan Event)
+func Group[T any](ctx context.Context, inc <-chan T, belong belongFunc[T]) (<-chan []T, <-chan error) {
+	outc := make(chan []T)
 	errc := make(chan error, 1)
-	drain := func(group []interface{}) {
+	stats := stageOf(ctx, "group")
+	drain := func(group []T) {
 		if len(group) > 0 {
-			select {
-			case <-ctx.Done():
-				return
-			case outc <- group:
-			}
+			publish(ctx, outc, group, stats)
 		}
 	}
 
 	go func() {
-		var group []interface{}
+		var group []T
 		defer func() {
 			// drain the last group
 			drain(group)
 			close(outc)
 			close(errc)
 		}()
+		start := stats.now()
 		for item := range inc {
+			stats.received(start)
 			select {
 			case <-ctx.Done():
-				errc <- errors.New("splitting rides canceled")
+				errc <- fmt.Errorf("group %w", ErrCanceled)
 				return
 			default:
 			}
 			// if the group is empty it means this is the item received
 			if len(group) == 0 {
 				group = append(group, item)
+				start = stats.now()
 				continue
 			}
+			start = stats.now()
 			ok, err := belong(item, group)
+			stats.processed(start)
 			if err != nil {
 				errc <- err
 				return
 			}
 			if ok {
 				group = append(group, item)
+				start = stats.now()
 				continue
 			}
 			// since the item did not belong to the group
 			// group is put to the out channel
-			if len(group) > 0 {
-				outc <- group
+			if len(group) > 0 && !publish(ctx, outc, group, stats) {
+				errc <- fmt.Errorf("group %w", ErrCanceled)
+				group = nil
+				return
 			}
 			// re-initialise the group by the current item
-			group = []interface{}{item}
-
+			group = []T{item}
+			start = stats.now()
 		}
 	}()
 	return outc, errc
@@ -114,54 +123,68 @@ func Group(ctx context.Context, inc <-chan Event, belong belongFunc) (<-chan Eve
 
 // Sink is a sinker which runs an eachFunc on each event
 // it is the final stage of the pipeline as it does not produce any channel
-func Sink(ctx context.Context, ch <-chan Event, fn eachFunc) error {
+func Sink[T any](ctx context.Context, ch <-chan T, fn eachFunc[T]) error {
+	stats := stageOf(ctx, "sink")
+	start := stats.now()
 	for r := range ch {
+		stats.received(start)
 		select {
 		case <-ctx.Done():
-			return errors.New("sink canceled")
+			return fmt.Errorf("sink %w", ErrCanceled)
 		default:
-			if err := fn(r); err != nil {
+			start = stats.now()
+			err := fn(r)
+			stats.processed(start)
+			if err != nil {
 				return err
 			}
 		}
+		start = stats.now()
 	}
 	return nil
 }
 
 // Reduce is a transformer that passes two subsequent events to a reduceFunc
-// and put the result to the output channel when the result is not nil
-func Reduce(ctx context.Context, inc <-chan Event, reduce reduceFunc) (<-chan Event, <-chan error) {
-	outc := make(chan Event)
+// and put the result to the output channel when the result is ok
+func Reduce[I, O any](ctx context.Context, inc <-chan I, reduce reduceFunc[I, O]) (<-chan O, <-chan error) {
+	outc := make(chan O)
 	errc := make(chan error, 1)
+	stats := stageOf(ctx, "reduce")
 	go func() {
 		defer func() {
 			close(outc)
 			close(errc)
 		}()
-		var last interface{}
+		var last I
+		first := true
+		start := stats.now()
 		for item := range inc {
+			stats.received(start)
 			// we need at least two items to pass to reduce
-			if last == nil {
-				last = item
+			if first {
+				last, first = item, false
+				start = stats.now()
 				continue
 			}
-			result, err := reduce(last, item)
+			start = stats.now()
+			result, ok, err := reduce(last, item)
+			stats.processed(start)
 
 			switch {
 			case err != nil:
 				errc <- err
 				return
-			case result == nil: // only put non nil values to output channel
+			case !ok: // only put ok values to output channel
+				start = stats.now()
 				continue
 			default:
 				last = item
 			}
 
-			select {
-			case outc <- result:
-			case <-ctx.Done():
+			if !publish(ctx, outc, result, stats) {
 				return
 			}
+			start = stats.now()
 		}
 	}()
 	return outc, errc
@@ -169,24 +192,42 @@ func Reduce(ctx context.Context, inc <-chan Event, reduce reduceFunc) (<-chan Ev
 
 // WorkerPool fans out the input channel to N worker which all publish on the output channel
 // if a worker returns an error during the consumption, the pool continues skips the current event
-// and spawns the worker again for the next item
-func WorkerPool(ctx context.Context, concurrency int, inc <-chan Event, worker workerFunc) (<-chan Event, <-chan error) {
+// and spawns the worker again for the next item. The first error is published to the error channel when the pool
+// exits, so the workers are never blocked on it, or the errors are collected by the Errors of the context,
+// see WithErrors.
+// With Stats, the results of an item are published when the worker is done with it, to tell the latency of
+// the worker apart from the time it is blocked on publishing
+func WorkerPool[I, O any](ctx context.Context, concurrency int, inc <-chan I, worker workerFunc[I, O]) (<-chan O, <-chan error) {
 	var wg sync.WaitGroup
-	outc := make(chan Event)
-	errc := make(chan error, concurrency)
+	var first firstError
+	outc := make(chan O)
+	errc := make(chan error, 1)
+	stats := stageOf(ctx, "worker pool")
+	errs := errorsOf(ctx)
+	if stats != nil {
+		worker = publishCollected(stats, worker)
+	}
 
 	wg.Add(concurrency)
 	for i := 0; i < concurrency; i++ {
 		go func() {
 			defer wg.Done()
+			start := stats.now()
 			for item := range inc {
+				if ctx.Err() != nil {
+					// the rest of the input is drained without processing it
+					continue
+				}
+				stats.received(start)
 				err := worker(ctx, item, outc)
-				if err != nil {
-					errc <- err
-					// worker could not work out the current item
-					// skip the current item but keep the worker
+				start = stats.now()
+				if errs != nil {
+					errs.add(err)
 					continue
 				}
+				// worker could not work out the current item
+				// skip the current item but keep the worker
+				first.add(err)
 			}
 		}()
 	}
@@ -194,12 +235,232 @@ func WorkerPool(ctx context.Context, concurrency int, inc <-chan Event, worker w
 	go func() {
 		wg.Wait()
 		close(outc)
-		close(errc)
+		first.publish(errc)
+	}()
+
+	return outc, errc
+}
+
+// firstError keeps the first error of the workers of a pool, it can be added to concurrently
+type firstError struct {
+	mu  sync.Mutex
+	err error
+}
+
+// add keeps the error if it is the first one, nil errors are ignored
+func (f *firstError) add(err error) {
+	if err == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+// publish publishes the first error to the error channel, which has room for it, and closes the channel
+func (f *firstError) publish(errc chan<- error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		errc <- f.err
+	}
+	close(errc)
+}
+
+// publishCollected returns a worker which collects the results of the worker on an item and then publishes them,
+// while collecting the metrics of the worker to the stage
+func publishCollected[I, O any](stats *stage, worker workerFunc[I, O]) workerFunc[I, O] {
+	return func(ctx context.Context, item I, outc chan<- O) error {
+		start := stats.now()
+		results, err := collect(ctx, item, worker)
+		stats.processed(start)
+		publishAll(ctx, outc, results, stats)
+		return err
+	}
+}
+
+// sequenced is an item numbered by its order in the input channel, along with its results
+type sequenced[T any] struct {
+	seq   int
+	value T
+}
+
+// OrderedWorkerPool fans out the input channel to N workers like WorkerPool, but it publishes the results of the items
+// in the order of the items in the input channel. At most window items are processed or held back at once until the
+// items before them are published, the input is not consumed while the window is full. A window less than 1
+// is the concurrency. If a worker returns an error, the results it published before are still published,
+// the error is handled like the errors of WorkerPool
+func OrderedWorkerPool[I, O any](ctx context.Context, concurrency, window int, inc <-chan I, worker workerFunc[I, O]) (<-chan O, <-chan error) {
+	if window < 1 {
+		window = concurrency
+	}
+	// stages are sequence and reorder, errc is closed when they and the workers exit
+	var wg, stages sync.WaitGroup
+	var first firstError
+	stages.Add(2)
+	slots := make(chan struct{}, window)
+	itemc := sequence(ctx, inc, slots, &stages)
+	resultc := make(chan sequenced[[]O])
+	errc := make(chan error, 1)
+	stats := stageOf(ctx, "ordered worker pool")
+	errs := errorsOf(ctx)
+
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			start := stats.now()
+			for item := range itemc {
+				stats.received(start)
+				start = stats.now()
+				results, err := collect(ctx, item.value, worker)
+				stats.processed(start)
+				if errs != nil {
+					errs.add(err)
+				} else {
+					first.add(err)
+				}
+				select {
+				case <-ctx.Done():
+				case resultc <- sequenced[[]O]{seq: item.seq, value: results}:
+				}
+				start = stats.now()
+			}
+		}()
+	}
+
+	outc := reorder(ctx, resultc, slots, stats, &stages)
+	go func() {
+		wg.Wait()
+		close(resultc)
+		stages.Wait()
+		first.publish(errc)
 	}()
 
 	return outc, errc
 }
 
+// sequence numbers the items of the input channel by their order, it lets an item in only when there is a free slot,
+// the slot is freed when the results of the item are published by reorder. done is marked when it exits
+func sequence[T any](ctx context.Context, inc <-chan T, slots chan struct{}, done *sync.WaitGroup) <-chan sequenced[T] {
+	outc := make(chan sequenced[T])
+	go func() {
+		defer func() {
+			close(outc)
+			// the previous stages are drained when it is canceled, so they are not blocked
+			for range inc {
+			}
+			done.Done()
+		}()
+		seq := 0
+		for item := range inc {
+			select {
+			case <-ctx.Done():
+				return
+			case slots <- struct{}{}:
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case outc <- sequenced[T]{seq: seq, value: item}:
+			}
+			seq++
+		}
+	}()
+	return outc
+}
+
+// collect runs the worker on an item and returns the results which it publishes
+func collect[I, O any](ctx context.Context, item I, worker workerFunc[I, O]) ([]O, error) {
+	outc := make(chan O)
+	done := make(chan struct{})
+	var results []O
+	go func() {
+		defer close(done)
+		for result := range outc {
+			results = append(results, result)
+		}
+	}()
+	err := worker(ctx, item, outc)
+	close(outc)
+	<-done
+	return results, err
+}
+
+// reorder holds back the results of the input channel until the results of the items before them are published,
+// then it publishes them and frees the slots of their items. done is marked when it exits
+func reorder[O any](ctx context.Context, inc <-chan sequenced[[]O], slots chan struct{}, stats *stage, done *sync.WaitGroup) <-chan O {
+	outc := make(chan O)
+	go func() {
+		defer func() {
+			close(outc)
+			// the workers are drained when it is canceled, so they are not blocked
+			for range inc {
+			}
+			done.Done()
+		}()
+		pending := make(map[int][]O)
+		next := 0
+		for item := range inc {
+			pending[item.seq] = item.value
+			for {
+				results, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				<-slots
+				if !publishAll(ctx, outc, results, stats) {
+					return
+				}
+			}
+			// the results which are left wait for the results before them
+			stats.held(int64(len(pending)))
+		}
+	}()
+	return outc
+}
+
+// publish sends an item to the output channel, it returns false if the context is canceled before.
+// The backlog of the stage is collected if it has to wait for the item to be received
+func publish[T any](ctx context.Context, outc chan<- T, item T, stats *stage) bool {
+	start := stats.sending()
+	if stats != nil {
+		select {
+		case outc <- item:
+			stats.sent(start)
+			return true
+		default:
+			stats.waiting()
+		}
+	}
+	select {
+	case <-ctx.Done():
+		stats.unsent()
+		return false
+	case outc <- item:
+		stats.sent(start)
+		return true
+	}
+}
+
+// publishAll publishes the items in order, they are all ready to be sent, so the ones which are not sent yet
+// are in the backlog of the stage. It returns false if the context is canceled before
+func publishAll[T any](ctx context.Context, outc chan<- T, items []T, stats *stage) bool {
+	stats.ready(int64(len(items)))
+	for i, item := range items {
+		stats.ready(-1)
+		if !publish(ctx, outc, item, stats) {
+			stats.ready(-int64(len(items) - i - 1))
+			return false
+		}
+	}
+	return true
+}
+
 // MergeErrors is a transformer which merges all input error channels into one output channel
 func MergeErrors(ctx context.Context, errs ...<-chan error) <-chan error {
 	var wg sync.WaitGroup
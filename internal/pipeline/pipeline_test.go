@@ -4,7 +4,9 @@ import (
 	"context"
 	"io"
 	"math/rand"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -12,22 +14,22 @@ import (
 func TestGenerate(t *testing.T) {
 	tests := []struct {
 		name      string
-		generator func() generateFunc
-		check     func(items <-chan Event, errc <-chan error)
+		generator func() generateFunc[int]
+		check     func(items <-chan int, errc <-chan error)
 	}{
 		{
 			name: "generates 10 numbers",
-			generator: func() generateFunc {
+			generator: func() generateFunc[int] {
 				i := 0
-				return func() (interface{}, error) {
+				return func() (int, bool, error) {
 					i++
 					if i <= 10 {
-						return rand.Int(), nil
+						return rand.Int(), true, nil
 					}
-					return 0, assert.AnError
+					return 0, false, assert.AnError
 				}
 			},
-			check: func(items <-chan Event, errc <-chan error) {
+			check: func(items <-chan int, errc <-chan error) {
 				count := 0
 				for _ = range items {
 					count++
@@ -37,18 +39,18 @@ func TestGenerate(t *testing.T) {
 			},
 		},
 		{
-			name: "skips nil",
-			generator: func() generateFunc {
+			name: "skips not ok",
+			generator: func() generateFunc[int] {
 				i := 0
-				return func() (interface{}, error) {
+				return func() (int, bool, error) {
 					i++
 					if i <= 10 {
-						return nil, nil
+						return i, false, nil
 					}
-					return 0, assert.AnError
+					return 0, false, assert.AnError
 				}
 			},
-			check: func(items <-chan Event, errc <-chan error) {
+			check: func(items <-chan int, errc <-chan error) {
 				count := 0
 				for _ = range items {
 					count++
@@ -68,21 +70,21 @@ func TestGenerate(t *testing.T) {
 func TestGroup(t *testing.T) {
 	tests := []struct {
 		name   string
-		inc    <-chan Event
-		belong func() belongFunc
-		check  func(items <-chan Event, errc <-chan error)
+		inc    <-chan int
+		belong func() belongFunc[int]
+		check  func(items <-chan []int, errc <-chan error)
 	}{
 		{
 			name: "group by number",
 			inc:  generateInt(t, []int{0, 0, 0, 1, 1, 1}),
-			belong: func() belongFunc {
-				return func(item interface{}, group []interface{}) (bool, error) {
-					citem := item.(int)
-					first := group[0].(int)
+			belong: func() belongFunc[int] {
+				return func(item int, group []int) (bool, error) {
+					citem := item
+					first := group[0]
 					return citem == first, nil
 				}
 			},
-			check: func(items <-chan Event, errc <-chan error) {
+			check: func(items <-chan []int, errc <-chan error) {
 				count := 0
 				for _ = range items {
 					count++
@@ -94,11 +96,11 @@ func TestGroup(t *testing.T) {
 		{
 			name: "belong returns error - drain happens",
 			inc:  generateInt(t, []int{0, 0, 0, 1, 1, 1}),
-			belong: func() belongFunc {
+			belong: func() belongFunc[int] {
 				i := 0
-				return func(item interface{}, group []interface{}) (bool, error) {
-					citem := item.(int)
-					first := group[0].(int)
+				return func(item int, group []int) (bool, error) {
+					citem := item
+					first := group[0]
 					if i == 4 {
 						return false, assert.AnError
 					}
@@ -106,10 +108,10 @@ func TestGroup(t *testing.T) {
 					return citem == first, nil
 				}
 			},
-			check: func(items <-chan Event, errc <-chan error) {
-				var lastGroup []interface{}
+			check: func(items <-chan []int, errc <-chan error) {
+				var lastGroup []int
 				for item := range items {
-					lastGroup = item.([]interface{})
+					lastGroup = item
 				}
 				assert.Equal(t, 2, len(lastGroup))
 				assert.Equal(t, assert.AnError, <-errc)
@@ -127,14 +129,14 @@ func TestGroup(t *testing.T) {
 func TestSink(t *testing.T) {
 	tests := []struct {
 		name   string
-		inc    <-chan Event
-		sinker eachFunc
+		inc    <-chan int
+		sinker eachFunc[int]
 		check  func(err error)
 	}{
 		{
 			name: "runs sink on all items",
 			inc:  generateInt(t, []int{1, 2, 3, 4, 5}),
-			sinker: func(val interface{}) error {
+			sinker: func(val int) error {
 				return nil
 			},
 			check: func(err error) {
@@ -144,8 +146,8 @@ func TestSink(t *testing.T) {
 		{
 			name: "sinker interrupts the sink",
 			inc:  generateInt(t, []int{1, 2, 3, 4, 5}),
-			sinker: func(val interface{}) error {
-				if val.(int) > 3 {
+			sinker: func(val int) error {
+				if val > 3 {
 					return assert.AnError
 				}
 				return nil
@@ -165,20 +167,20 @@ func TestSink(t *testing.T) {
 func TestReduce(t *testing.T) {
 	tests := []struct {
 		name    string
-		inc     <-chan Event
-		reducer reduceFunc
-		check   func(outc <-chan Event, errc <-chan error)
+		inc     <-chan int
+		reducer reduceFunc[int, int]
+		check   func(outc <-chan int, errc <-chan error)
 	}{
 		{
 			name: "adds last two items",
 			inc:  generateInt(t, []int{1, 1, 2, 2}),
-			reducer: func(i interface{}, j interface{}) (interface{}, error) {
-				return i.(int) + j.(int), nil
+			reducer: func(i int, j int) (int, bool, error) {
+				return i + j, true, nil
 			},
-			check: func(outc <-chan Event, errc <-chan error) {
+			check: func(outc <-chan int, errc <-chan error) {
 				total := 0
 				for item := range outc {
-					total += item.(int)
+					total += item
 				}
 				// (1+1) + (1+2) + (2+2) = 9
 				assert.Equal(t, 9, total)
@@ -188,16 +190,16 @@ func TestReduce(t *testing.T) {
 		{
 			name: "interrupt the reduce by an error",
 			inc:  generateInt(t, []int{1, 1, 2, 2}),
-			reducer: func(i interface{}, j interface{}) (interface{}, error) {
-				if i.(int) == 2 {
-					return nil, assert.AnError
+			reducer: func(i int, j int) (int, bool, error) {
+				if i == 2 {
+					return 0, false, assert.AnError
 				}
-				return i.(int) + j.(int), nil
+				return i + j, true, nil
 			},
-			check: func(outc <-chan Event, errc <-chan error) {
+			check: func(outc <-chan int, errc <-chan error) {
 				total := 0
 				for item := range outc {
-					total += item.(int)
+					total += item
 				}
 				// (1+1) + (1+2) + (2...error) = 5
 				assert.Equal(t, 5, total)
@@ -217,22 +219,22 @@ func TestWorkerPool(t *testing.T) {
 	tests := []struct {
 		name        string
 		concurrency int
-		inc         <-chan Event
-		worker      workerFunc
-		check       func(outc <-chan Event, errc <-chan error)
+		inc         <-chan int
+		worker      workerFunc[int, int]
+		check       func(outc <-chan int, errc <-chan error)
 	}{
 		{
 			name:        "2 adders",
 			concurrency: 2,
 			inc:         generateInt(t, []int{1, 2, 3, 4, 5}),
-			worker: func(ctx context.Context, item interface{}, outc chan<- Event) error {
-				outc <- item.(int) * 10
+			worker: func(ctx context.Context, item int, outc chan<- int) error {
+				outc <- item * 10
 				return nil
 			},
-			check: func(outc <-chan Event, errc <-chan error) {
+			check: func(outc <-chan int, errc <-chan error) {
 				total := 0
 				for item := range outc {
-					total += item.(int)
+					total += item
 				}
 				assert.Equal(t, 150, total)
 				assert.Nil(t, <-errc)
@@ -242,23 +244,37 @@ func TestWorkerPool(t *testing.T) {
 			name:        "2 adders, just add the first three items",
 			concurrency: 2,
 			inc:         generateInt(t, []int{1, 2, 3, 4, 5}),
-			worker: func(ctx context.Context, item interface{}, outc chan<- Event) error {
-				if item.(int) > 3 {
+			worker: func(ctx context.Context, item int, outc chan<- int) error {
+				if item > 3 {
 					return assert.AnError
 				}
-				outc <- item.(int) * 10
+				outc <- item * 10
 				return nil
 			},
-			check: func(outc <-chan Event, errc <-chan error) {
+			check: func(outc <-chan int, errc <-chan error) {
 				total := 0
 				for item := range outc {
-					total += item.(int)
+					total += item
 				}
 				assert.Greater(t, total, 0)
 				assert.NotEqual(t, 150, total)
 				assert.Equal(t, assert.AnError, <-errc)
 			},
 		},
+		{
+			name:        "more errors than workers",
+			concurrency: 2,
+			inc:         generateInt(t, []int{1, 2, 3, 4, 5, 6, 7, 8}),
+			worker: func(ctx context.Context, item int, outc chan<- int) error {
+				return assert.AnError
+			},
+			check: func(outc <-chan int, errc <-chan error) {
+				for range outc {
+				}
+				assert.Equal(t, assert.AnError, <-errc)
+				assert.Nil(t, <-errc)
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -268,6 +284,125 @@ func TestWorkerPool(t *testing.T) {
 	}
 }
 
+func TestOrderedWorkerPool(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		window      int
+		inc         <-chan int
+		worker      workerFunc[int, int]
+		want        []int
+		err         error
+	}{
+		{
+			name:        "results in the order of the items",
+			concurrency: 4,
+			window:      2,
+			inc:         generateInt(t, []int{1, 2, 3, 4, 5, 6, 7, 8}),
+			worker: func(ctx context.Context, item int, outc chan<- int) error {
+				// the first items take the longest
+				time.Sleep(time.Duration(10-item) * time.Millisecond)
+				outc <- item * 10
+				outc <- item*10 + 1
+				return nil
+			},
+			want: []int{10, 11, 20, 21, 30, 31, 40, 41, 50, 51, 60, 61, 70, 71, 80, 81},
+		},
+		{
+			name:        "results published before an error are kept",
+			concurrency: 2,
+			inc:         generateInt(t, []int{1, 2, 3, 4, 5}),
+			worker: func(ctx context.Context, item int, outc chan<- int) error {
+				outc <- item * 10
+				if item == 3 {
+					return assert.AnError
+				}
+				return nil
+			},
+			want: []int{10, 20, 30, 40, 50},
+			err:  assert.AnError,
+		},
+		{
+			name:        "more errors than workers",
+			concurrency: 2,
+			inc:         generateInt(t, []int{1, 2, 3, 4, 5, 6, 7, 8}),
+			worker: func(ctx context.Context, item int, outc chan<- int) error {
+				return assert.AnError
+			},
+			err: assert.AnError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outc, errc := OrderedWorkerPool(context.TODO(), test.concurrency, test.window, test.inc, test.worker)
+			var got []int
+			for item := range outc {
+				got = append(got, item)
+			}
+			assert.Equal(t, test.want, got)
+			assert.Equal(t, test.err, <-errc)
+		})
+	}
+}
+
+func TestSequence(t *testing.T) {
+	slots := make(chan struct{}, 2)
+	inc := make(chan string, 3)
+	inc <- "a"
+	inc <- "b"
+	inc <- "c"
+	close(inc)
+
+	outc := sequence(context.TODO(), inc, slots, newDone())
+	assert.Equal(t, sequenced[string]{seq: 0, value: "a"}, <-outc)
+	assert.Equal(t, sequenced[string]{seq: 1, value: "b"}, <-outc)
+
+	// the slots are full until an item leaves them
+	select {
+	case <-outc:
+		t.Fatal("sequence exceeded the slots")
+	default:
+	}
+	<-slots
+	assert.Equal(t, sequenced[string]{seq: 2, value: "c"}, <-outc)
+}
+
+func TestSequence_canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	inc := make(chan int)
+	outc := sequence(ctx, inc, make(chan struct{}, 1), newDone())
+
+	// the input is drained, so the previous stage is not blocked
+	for i := 0; i < 3; i++ {
+		inc <- i
+	}
+	close(inc)
+	for range outc {
+	}
+}
+
+func TestReorder(t *testing.T) {
+	slots := make(chan struct{}, 3)
+	inc := make(chan sequenced[[]int], 3)
+	for i := 0; i < 3; i++ {
+		slots <- struct{}{}
+	}
+	inc <- sequenced[[]int]{seq: 2, value: []int{3}}
+	inc <- sequenced[[]int]{seq: 0, value: []int{1, 2}}
+	inc <- sequenced[[]int]{seq: 1}
+	close(inc)
+
+	var got []int
+	for item := range reorder(context.TODO(), inc, slots, nil, newDone()) {
+		got = append(got, item)
+	}
+
+	assert.Equal(t, []int{1, 2, 3}, got)
+	assert.Len(t, slots, 0)
+}
+
 func TestMergeErrors(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -296,16 +431,23 @@ func TestMergeErrors(t *testing.T) {
 	}
 }
 
-func generateInt(t *testing.T, items []int) <-chan Event {
+// newDone returns a WaitGroup of one stage
+func newDone() *sync.WaitGroup {
+	var done sync.WaitGroup
+	done.Add(1)
+	return &done
+}
+
+func generateInt(t *testing.T, items []int) <-chan int {
 	t.Helper()
 	i := 0
-	outc, _ := Generate(context.TODO(), func() (interface{}, error) {
+	outc, _ := Generate(context.TODO(), func() (int, bool, error) {
 		if i >= len(items) {
-			return nil, assert.AnError
+			return 0, false, assert.AnError
 		}
 		ret := items[i]
 		i++
-		return ret, nil
+		return ret, true, nil
 	})
 	return outc
 }
@@ -0,0 +1,78 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrCanceled is wrapped by the errors of the stages which stop because their context is canceled
+var ErrCanceled = errors.New("canceled")
+
+// Runner runs the stages created with its context until all of them exit
+type Runner struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	errcs  []<-chan error
+}
+
+// NewRunner creates a Runner of a context which is derived from ctx
+func NewRunner(ctx context.Context) *Runner {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Runner{ctx: ctx, cancel: cancel}
+}
+
+// Context returns the context of the stages of the runner, it is canceled when Run returns
+func (r *Runner) Context() context.Context {
+	return r.ctx
+}
+
+// Add adds the error channels of stages, a stage should close its error channel when it exits
+func (r *Runner) Add(errcs ...<-chan error) {
+	r.errcs = append(r.errcs, errcs...)
+}
+
+// Run runs the sink of the stages, e.g. a Sink of the output of the last stage. When the sink returns, the stages
+// which are still running are canceled and Run waits for all of them to exit, so no stage outlives it.
+// It returns the first error of the stages, unless it is io.EOF which ends a Generate or it is the cancellation of
+// a stage, otherwise the error of the sink. An error wrapping ErrCanceled is returned if the context of the runner
+// is canceled before the sink returns
+func (r *Runner) Run(sink func(ctx context.Context) error) error {
+	defer r.cancel()
+
+	var mu sync.Mutex
+	var first error
+	var wg sync.WaitGroup
+	wg.Add(len(r.errcs))
+	for _, errc := range r.errcs {
+		go func(errc <-chan error) {
+			defer wg.Done()
+			// the error channel is drained until the stage exits, so the stage is never blocked on it
+			for err := range errc {
+				if err == nil || err == io.EOF || errors.Is(err, ErrCanceled) {
+					continue
+				}
+				mu.Lock()
+				if first == nil {
+					first = err
+				}
+				mu.Unlock()
+			}
+		}(errc)
+	}
+
+	err := sink(r.ctx)
+	canceled := r.ctx.Err() != nil
+	r.cancel()
+	wg.Wait()
+
+	switch {
+	case first != nil && (err == nil || errors.Is(err, ErrCanceled)):
+		return first
+	case err == nil && canceled:
+		return fmt.Errorf("run %w", ErrCanceled)
+	}
+	return err
+}
@@ -0,0 +1,151 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunner_Run(t *testing.T) {
+	tests := []struct {
+		name   string
+		cancel bool
+		// generate is the generateFunc of the first stage, it never ends by default
+		generate generateFunc[int]
+		sink     func(ctx context.Context, inc <-chan int) error
+		err      error
+	}{
+		{
+			name: "ends with io.EOF",
+			generate: func() generateFunc[int] {
+				i := 0
+				return func() (int, bool, error) {
+					if i == 3 {
+						return 0, false, io.EOF
+					}
+					i++
+					return i, true, nil
+				}
+			}(),
+			sink: func(ctx context.Context, inc <-chan int) error {
+				return Sink(ctx, inc, func(val int) error { return nil })
+			},
+		},
+		{
+			name: "returns the error of a stage",
+			generate: func() (int, bool, error) {
+				return 0, false, assert.AnError
+			},
+			sink: func(ctx context.Context, inc <-chan int) error {
+				return Sink(ctx, inc, func(val int) error { return nil })
+			},
+			err: assert.AnError,
+		},
+		{
+			name: "returns the error of the sink and stops the stages",
+			generate: func() (int, bool, error) {
+				return 1, true, nil
+			},
+			sink: func(ctx context.Context, inc <-chan int) error {
+				<-inc
+				return assert.AnError
+			},
+			err: assert.AnError,
+		},
+		{
+			name: "sink stops reading without an error",
+			generate: func() (int, bool, error) {
+				return 1, true, nil
+			},
+			sink: func(ctx context.Context, inc <-chan int) error {
+				<-inc
+				return nil
+			},
+		},
+		{
+			name:   "canceled",
+			cancel: true,
+			generate: func() (int, bool, error) {
+				return 1, true, nil
+			},
+			sink: func(ctx context.Context, inc <-chan int) error {
+				return Sink(ctx, inc, func(val int) error { return nil })
+			},
+			err: ErrCanceled,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			goroutines := runtime.NumGoroutine()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.cancel {
+				cancel()
+			}
+
+			runner := NewRunner(ctx)
+			linec, errc1 := Generate(runner.Context(), test.generate)
+			groupc, errc2 := Group(runner.Context(), linec, func(item int, group []int) (bool, error) {
+				return len(group) < 2, nil
+			})
+			outc, errc3 := WorkerPool(runner.Context(), 2, groupc, func(ctx context.Context, group []int, outc chan<- int) error {
+				for _, item := range group {
+					select {
+					case <-ctx.Done():
+						return nil
+					case outc <- item:
+					}
+				}
+				return nil
+			})
+			runner.Add(errc1, errc2, errc3)
+
+			err := runner.Run(func(ctx context.Context) error {
+				return test.sink(ctx, outc)
+			})
+			if test.err != nil {
+				assert.True(t, errors.Is(err, test.err), "unexpected error %v", err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assertNoLeak(t, goroutines)
+		})
+	}
+}
+
+func TestOrderedWorkerPool_canceled(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	runner := NewRunner(context.Background())
+	itemc, errc1 := Generate(runner.Context(), func() (int, bool, error) {
+		return 1, true, nil
+	})
+	outc, errc2 := OrderedWorkerPool(runner.Context(), 4, 8, itemc, func(ctx context.Context, item int, outc chan<- int) error {
+		outc <- item
+		return assert.AnError
+	})
+	runner.Add(errc1, errc2)
+
+	err := runner.Run(func(ctx context.Context) error {
+		<-outc
+		return nil
+	})
+	assert.Equal(t, assert.AnError, err)
+	assertNoLeak(t, goroutines)
+}
+
+// assertNoLeak checks if the number of goroutines goes back to the given number,
+// the goroutines which are done may take a while to exit
+func assertNoLeak(t *testing.T, goroutines int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines, "goroutines are leaked")
+}
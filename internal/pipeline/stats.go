@@ -0,0 +1,311 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// contextKey is the type of the keys of the context values of the pipeline
+type contextKey int
+
+const (
+	statsKey contextKey = iota
+	stageKey
+	errorsKey
+)
+
+// latencyBounds are the upper bounds of the buckets of the latency histograms, the last bucket has no bound
+var latencyBounds = []time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// Stats collects the metrics of the stages of the pipelines which run with its context, see WithStats.
+// The stages of the same name are collected together, e.g. the stages of a pipeline which runs for each item
+type Stats struct {
+	mu     sync.Mutex
+	stages map[string]*stage
+	// names are the names of the stages in the order of their start
+	names []string
+}
+
+// NewStats creates a Stats
+func NewStats() *Stats {
+	return &Stats{stages: make(map[string]*stage)}
+}
+
+// WithStats returns a context which makes the stages created with it collect their metrics into the stats
+func WithStats(ctx context.Context, stats *Stats) context.Context {
+	return context.WithValue(ctx, statsKey, stats)
+}
+
+// Stage returns a context which names the stages created with it, by default a stage is named by its kind.
+// The context is returned as it is if it has no stats
+func Stage(ctx context.Context, name string) context.Context {
+	if ctx.Value(statsKey) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, stageKey, name)
+}
+
+// StageStats are the metrics of a stage
+type StageStats struct {
+	Name string
+	// In and Out are the number of items received and published by the stage
+	In, Out int64
+	// BlockedReceive and BlockedSend are the total time the stage waited for an item of its input channel
+	// and for the next stage to receive its items
+	BlockedReceive, BlockedSend time.Duration
+	// Latency is the histogram of the time of processing an item
+	Latency Histogram
+	// MaxQueue is the maximum backlog of the stage, the number of items which are ready to be sent by the stage
+	// and are not received yet by the next stage, it is only measured when the stage has to wait for the next one
+	MaxQueue int64
+	// MaxHeld is the maximum number of items held back by the stage, i.e. the results which wait for the results
+	// before them in OrderedWorkerPool and the open windows of SlidingWindow
+	MaxHeld int64
+}
+
+// Histogram counts durations in the buckets of latencyBounds
+type Histogram struct {
+	// Counts are the number of durations of each bucket, the last bucket is of the durations above all bounds
+	Counts []int64
+}
+
+// Total returns the number of durations of the histogram
+func (h Histogram) Total() int64 {
+	var total int64
+	for _, count := range h.Counts {
+		total += count
+	}
+	return total
+}
+
+// Quantile returns the upper bound of the bucket of the q quantile, 0 <= q <= 1,
+// it is 0 for an empty histogram and -1 if the quantile is above all bounds
+func (h Histogram) Quantile(q float64) time.Duration {
+	total := h.Total()
+	if total == 0 {
+		return 0
+	}
+	rank := int64(q * float64(total))
+	if rank >= total {
+		rank = total - 1
+	}
+	var seen int64
+	for i, count := range h.Counts {
+		seen += count
+		if seen > rank {
+			if i < len(latencyBounds) {
+				return latencyBounds[i]
+			}
+			break
+		}
+	}
+	return -1
+}
+
+// Stages returns the metrics of the stages in the order of their start
+func (s *Stats) Stages() []StageStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stages := make([]StageStats, 0, len(s.names))
+	for _, name := range s.names {
+		stages = append(stages, s.stages[name].snapshot(name))
+	}
+	return stages
+}
+
+// WriteTo writes the metrics of the stages as a table
+func (s *Stats) WriteTo(w io.Writer) (int64, error) {
+	c := &countWriter{writer: w}
+	tw := tabwriter.NewWriter(c, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "stage\tin\tout\tblocked receive\tblocked send\tmax queue\tmax held\tp50\tp99\t")
+	for _, stage := range s.Stages() {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%d\t%d\t%s\t%s\t\n", stage.Name, stage.In, stage.Out,
+			stage.BlockedReceive.Round(time.Millisecond), stage.BlockedSend.Round(time.Millisecond), stage.MaxQueue,
+			stage.MaxHeld, formatBound(stage.Latency.Quantile(0.5)), formatBound(stage.Latency.Quantile(0.99)))
+	}
+	err := tw.Flush()
+	return c.count, err
+}
+
+// formatBound formats a bound of a quantile
+func formatBound(d time.Duration) string {
+	switch {
+	case d < 0:
+		return ">" + latencyBounds[len(latencyBounds)-1].String()
+	case d == 0:
+		return "-"
+	default:
+		return "<" + d.String()
+	}
+}
+
+// countWriter counts the bytes written to a writer
+type countWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// stage collects the metrics of a stage, its methods can be called concurrently
+// the methods of a nil stage do nothing, so a stage without stats costs nothing
+type stage struct {
+	in, out                     int64
+	blockedReceive, blockedSend int64
+	// backlog is the number of items which are ready to be sent and are not received yet by the next stage
+	backlog  int64
+	maxQueue int64
+	maxHeld  int64
+	latency  []int64
+}
+
+// stageOf returns the stage of the stats of the context by the name of the context or the kind,
+// nil if the context has no stats
+func stageOf(ctx context.Context, kind string) *stage {
+	stats, ok := ctx.Value(statsKey).(*Stats)
+	if !ok || stats == nil {
+		return nil
+	}
+	name, ok := ctx.Value(stageKey).(string)
+	if !ok {
+		name = kind
+	}
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	s, ok := stats.stages[name]
+	if !ok {
+		s = &stage{latency: make([]int64, len(latencyBounds)+1)}
+		stats.stages[name] = s
+		stats.names = append(stats.names, name)
+	}
+	return s
+}
+
+// now returns the current time if the stage collects metrics
+func (s *stage) now() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// received collects an item received since the given time
+func (s *stage) received(since time.Time) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.in, 1)
+	atomic.AddInt64(&s.blockedReceive, int64(time.Since(since)))
+}
+
+// ready adds n items which are ready to be sent to the backlog of the stage, a negative n removes them
+func (s *stage) ready(n int64) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.backlog, n)
+}
+
+// sending collects an item which the stage starts to send and returns the current time,
+// the item is in the backlog until it is sent or unsent
+func (s *stage) sending() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	atomic.AddInt64(&s.backlog, 1)
+	return time.Now()
+}
+
+// waiting collects the backlog of the stage when it has to wait for the next stage to receive an item
+func (s *stage) waiting() {
+	if s == nil {
+		return
+	}
+	setMax(&s.maxQueue, atomic.LoadInt64(&s.backlog))
+}
+
+// unsent collects an item which is not sent, as the stage is canceled
+func (s *stage) unsent() {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.backlog, -1)
+}
+
+// held collects the number of items which are held back by the stage
+func (s *stage) held(n int64) {
+	if s == nil {
+		return
+	}
+	setMax(&s.maxHeld, n)
+}
+
+// setMax sets max to the value if it is greater
+func setMax(max *int64, value int64) {
+	for {
+		current := atomic.LoadInt64(max)
+		if value <= current || atomic.CompareAndSwapInt64(max, current, value) {
+			return
+		}
+	}
+}
+
+// processed collects the latency of an item processed since the given time
+func (s *stage) processed(since time.Time) {
+	if s == nil {
+		return
+	}
+	latency := time.Since(since)
+	i := 0
+	for i < len(latencyBounds) && latency > latencyBounds[i] {
+		i++
+	}
+	atomic.AddInt64(&s.latency[i], 1)
+}
+
+// sent collects an item sent since the given time
+func (s *stage) sent(since time.Time) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.backlog, -1)
+	atomic.AddInt64(&s.out, 1)
+	atomic.AddInt64(&s.blockedSend, int64(time.Since(since)))
+}
+
+// snapshot returns the metrics of the stage
+func (s *stage) snapshot(name string) StageStats {
+	latency := make([]int64, len(s.latency))
+	for i := range s.latency {
+		latency[i] = atomic.LoadInt64(&s.latency[i])
+	}
+	return StageStats{
+		Name:           name,
+		In:             atomic.LoadInt64(&s.in),
+		Out:            atomic.LoadInt64(&s.out),
+		BlockedReceive: time.Duration(atomic.LoadInt64(&s.blockedReceive)),
+		BlockedSend:    time.Duration(atomic.LoadInt64(&s.blockedSend)),
+		Latency:        Histogram{Counts: latency},
+		MaxQueue:       atomic.LoadInt64(&s.maxQueue),
+		MaxHeld:        atomic.LoadInt64(&s.maxHeld),
+	}
+}
@@ -0,0 +1,153 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	stats := NewStats()
+	ctx := WithStats(context.TODO(), stats)
+
+	i := 0
+	numbers, _ := Generate(Stage(ctx, "numbers"), func() (int, bool, error) {
+		i++
+		if i > 5 {
+			return 0, false, assert.AnError
+		}
+		// the odd numbers are skipped
+		return i, i%2 == 0, nil
+	})
+	doubled, _ := WorkerPool(ctx, 2, numbers, func(ctx context.Context, item int, outc chan<- int) error {
+		outc <- item
+		outc <- item
+		return nil
+	})
+	total := 0
+	err := Sink(Stage(ctx, "sum"), doubled, func(val int) error {
+		total += val
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 12, total)
+
+	stages := stats.Stages()
+	assert.Len(t, stages, 3)
+	for _, stage := range stages {
+		stage.BlockedReceive, stage.BlockedSend, stage.MaxQueue = 0, 0, 0
+		stage.Latency = Histogram{Counts: []int64{stage.Latency.Total()}}
+		switch stage.Name {
+		case "numbers":
+			assert.Equal(t, StageStats{Name: "numbers", Out: 2, Latency: Histogram{Counts: []int64{6}}}, stage)
+		case "worker pool":
+			assert.Equal(t, StageStats{Name: "worker pool", In: 2, Out: 4, Latency: Histogram{Counts: []int64{2}}}, stage)
+		case "sum":
+			assert.Equal(t, StageStats{Name: "sum", In: 4, Latency: Histogram{Counts: []int64{4}}}, stage)
+		default:
+			t.Errorf("unexpected stage %q", stage.Name)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	_, err = stats.WriteTo(out)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 4)
+	assert.Equal(t, []string{"stage", "in", "out", "blocked", "receive", "blocked", "send", "max", "queue", "max", "held", "p50", "p99"},
+		strings.Fields(lines[0]))
+}
+
+func TestStats_maxQueue(t *testing.T) {
+	stats := NewStats()
+	ctx := WithStats(context.TODO(), stats)
+
+	outc, _ := WorkerPool(ctx, 3, generateInt(t, []int{1, 2, 3}), func(ctx context.Context, item int, outc chan<- int) error {
+		outc <- item
+		return nil
+	})
+	first := true
+	err := Sink(Stage(ctx, "slow"), outc, func(val int) error {
+		if first {
+			// the other workers are blocked on sending their items meanwhile
+			time.Sleep(50 * time.Millisecond)
+			first = false
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+
+	for _, stage := range stats.Stages() {
+		if stage.Name == "worker pool" {
+			assert.GreaterOrEqual(t, stage.MaxQueue, int64(2))
+		}
+	}
+}
+
+func TestStats_maxQueue_results(t *testing.T) {
+	stats := NewStats()
+	ctx := WithStats(context.TODO(), stats)
+
+	// a single worker has all results of its item ready at once, so they are all in the backlog
+	outc, _ := WorkerPool(ctx, 1, generateInt(t, []int{1}), func(ctx context.Context, item int, outc chan<- int) error {
+		for i := 0; i < 3; i++ {
+			outc <- item
+		}
+		return nil
+	})
+	err := Sink(Stage(ctx, "slow"), outc, func(val int) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	for _, stage := range stats.Stages() {
+		if stage.Name == "worker pool" {
+			assert.GreaterOrEqual(t, stage.MaxQueue, int64(2))
+			assert.LessOrEqual(t, stage.MaxQueue, int64(3))
+			assert.Equal(t, int64(0), stage.MaxHeld)
+		}
+	}
+}
+
+func TestStats_maxHeld(t *testing.T) {
+	stats := NewStats()
+	ctx := WithStats(context.TODO(), stats)
+
+	outc, _ := OrderedWorkerPool(ctx, 2, 0, generateInt(t, []int{1, 2}), func(ctx context.Context, item int, outc chan<- int) error {
+		if item == 1 {
+			// the result of the second item is held back meanwhile
+			time.Sleep(50 * time.Millisecond)
+		}
+		outc <- item
+		return nil
+	})
+	err := Sink(ctx, outc, func(val int) error { return nil })
+	assert.Nil(t, err)
+
+	for _, stage := range stats.Stages() {
+		if stage.Name == "ordered worker pool" {
+			assert.Equal(t, int64(1), stage.MaxHeld)
+		}
+	}
+}
+
+func TestStats_disabled(t *testing.T) {
+	assert.Nil(t, stageOf(Stage(context.TODO(), "numbers"), "generate"))
+}
+
+func TestHistogram_Quantile(t *testing.T) {
+	counts := make([]int64, len(latencyBounds)+1)
+	counts[0], counts[3], counts[len(latencyBounds)] = 50, 49, 1
+	h := Histogram{Counts: counts}
+
+	assert.Equal(t, int64(100), h.Total())
+	assert.Equal(t, time.Microsecond, h.Quantile(0.3))
+	assert.Equal(t, time.Millisecond, h.Quantile(0.5))
+	assert.Equal(t, time.Duration(-1), h.Quantile(1))
+	assert.Equal(t, time.Duration(0), Histogram{}.Quantile(0.5))
+}
@@ -0,0 +1,205 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// timeFunc returns the event time of an item
+type timeFunc[T any] func(item T) time.Time
+
+// Window is the items of a time window, in the order they are received
+type Window[T any] struct {
+	// Start is inclusive and End is exclusive, the End of a session is its last event time plus the gap
+	Start, End time.Time
+	Items      []T
+}
+
+// Batch is a transformer that groups the items of the input channel into batches of size items,
+// the last batch has the rest of the items
+func Batch[T any](ctx context.Context, inc <-chan T, size int) (<-chan []T, <-chan error) {
+	if size < 1 {
+		return invalid[T, []T](ctx, inc, errors.New("batch size should be positive"))
+	}
+	outc := make(chan []T)
+	errc := make(chan error, 1)
+	stats := stageOf(ctx, "batch")
+	go func() {
+		defer func() {
+			close(outc)
+			close(errc)
+		}()
+		var batch []T
+		start := stats.now()
+		for item := range inc {
+			stats.received(start)
+			batch = append(batch, item)
+			if len(batch) == size {
+				if !publish(ctx, outc, batch, stats) {
+					errc <- fmt.Errorf("batch %w", ErrCanceled)
+					return
+				}
+				batch = nil
+			}
+			start = stats.now()
+		}
+		if len(batch) > 0 && !publish(ctx, outc, batch, stats) {
+			errc <- fmt.Errorf("batch %w", ErrCanceled)
+		}
+	}()
+	return outc, errc
+}
+
+// TumblingWindow is a transformer that groups the items of the input channel into consecutive windows of the
+// given size by their event time, see SlidingWindow
+func TumblingWindow[T any](ctx context.Context, inc <-chan T, size time.Duration, eventTime timeFunc[T]) (<-chan Window[T], <-chan error) {
+	return SlidingWindow(ctx, inc, size, size, eventTime)
+}
+
+// SlidingWindow is a transformer that groups the items of the input channel into windows of the given size which
+// start every slide by their event time, so an item is in size/slide windows. The windows are aligned to the zero time.
+// The watermark is the latest event time received, a window is published when the watermark reaches its end,
+// and the rest at the end of the input. An item is dropped if all of its windows are published, so the items
+// are expected in the order of their event time. Windows without items are not published
+func SlidingWindow[T any](ctx context.Context, inc <-chan T, size, slide time.Duration, eventTime timeFunc[T]) (<-chan Window[T], <-chan error) {
+	if size <= 0 || slide <= 0 {
+		return invalid[T, Window[T]](ctx, inc, errors.New("window size and slide should be positive"))
+	}
+	outc := make(chan Window[T])
+	errc := make(chan error, 1)
+	stats := stageOf(ctx, "window")
+	go func() {
+		defer func() {
+			close(outc)
+			close(errc)
+		}()
+
+		// the open windows are keyed by the unix time of their start
+		open := make(map[int64]*Window[T])
+		var watermark time.Time
+		// flush publishes the open windows which end by the given time, in the order of their start
+		flush := func(until time.Time, all bool) bool {
+			var ready []*Window[T]
+			for start, w := range open {
+				if all || !w.End.After(until) {
+					ready = append(ready, w)
+					delete(open, start)
+				}
+			}
+			sort.Slice(ready, func(i, j int) bool { return ready[i].Start.Before(ready[j].Start) })
+			for _, w := range ready {
+				if !publish(ctx, outc, *w, stats) {
+					return false
+				}
+			}
+			return true
+		}
+
+		start := stats.now()
+		for item := range inc {
+			stats.received(start)
+			stats.held(int64(len(open)))
+			start = stats.now()
+			t := eventTime(item)
+			for s := t.Truncate(slide); s.Add(size).After(t); s = s.Add(-slide) {
+				end := s.Add(size)
+				if !watermark.IsZero() && !end.After(watermark) {
+					// the window is already published
+					break
+				}
+				w, ok := open[s.UnixNano()]
+				if !ok {
+					w = &Window[T]{Start: s, End: end}
+					open[s.UnixNano()] = w
+				}
+				w.Items = append(w.Items, item)
+			}
+			if t.After(watermark) {
+				watermark = t
+			}
+			stats.processed(start)
+			if !flush(watermark, false) {
+				errc <- fmt.Errorf("window %w", ErrCanceled)
+				return
+			}
+			start = stats.now()
+		}
+		if !flush(watermark, true) {
+			errc <- fmt.Errorf("window %w", ErrCanceled)
+		}
+	}()
+	return outc, errc
+}
+
+// SessionWindow is a transformer that groups the items of the input channel into sessions by their event time,
+// a session is closed by an item which comes more than gap after the latest item of the session.
+// The items are expected in the order of their event time, an item before the session is added to it
+func SessionWindow[T any](ctx context.Context, inc <-chan T, gap time.Duration, eventTime timeFunc[T]) (<-chan Window[T], <-chan error) {
+	if gap <= 0 {
+		return invalid[T, Window[T]](ctx, inc, errors.New("session gap should be positive"))
+	}
+	outc := make(chan Window[T])
+	errc := make(chan error, 1)
+	stats := stageOf(ctx, "session")
+	go func() {
+		defer func() {
+			close(outc)
+			close(errc)
+		}()
+
+		var session *Window[T]
+		start := stats.now()
+		for item := range inc {
+			stats.received(start)
+			t := eventTime(item)
+			if session != nil && t.After(session.End) {
+				if !publish(ctx, outc, *session, stats) {
+					errc <- fmt.Errorf("session %w", ErrCanceled)
+					return
+				}
+				session = nil
+			}
+			if session == nil {
+				session = &Window[T]{Start: t, End: t.Add(gap)}
+			}
+			if t.Before(session.Start) {
+				session.Start = t
+			}
+			if end := t.Add(gap); end.After(session.End) {
+				session.End = end
+			}
+			session.Items = append(session.Items, item)
+			start = stats.now()
+		}
+		if session != nil && !publish(ctx, outc, *session, stats) {
+			errc <- fmt.Errorf("session %w", ErrCanceled)
+		}
+	}()
+	return outc, errc
+}
+
+// invalid returns the closed output channel of a stage which can't run, along with its error. The input channel is
+// drained until it is closed or the context is canceled, so the previous stage is not blocked on it
+func invalid[I, O any](ctx context.Context, inc <-chan I, err error) (<-chan O, <-chan error) {
+	outc := make(chan O)
+	errc := make(chan error, 1)
+	close(outc)
+	errc <- err
+	close(errc)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-inc:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+	return outc, errc
+}
@@ -0,0 +1,256 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []int
+		size  int
+		want  [][]int
+		err   bool
+	}{
+		{
+			name:  "the last batch has the rest",
+			items: []int{1, 2, 3, 4, 5},
+			size:  2,
+			want:  [][]int{{1, 2}, {3, 4}, {5}},
+		},
+		{
+			name:  "full batches",
+			items: []int{1, 2, 3, 4},
+			size:  2,
+			want:  [][]int{{1, 2}, {3, 4}},
+		},
+		{
+			name:  "invalid size",
+			items: []int{1},
+			size:  0,
+			err:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outc, errc := Batch(context.TODO(), sliceOf(test.items), test.size)
+			var got [][]int
+			for batch := range outc {
+				got = append(got, batch)
+			}
+			assert.Equal(t, test.want, got)
+			assert.Equal(t, test.err, <-errc != nil)
+		})
+	}
+}
+
+// at is an item at the given second
+type at int
+
+func (a at) time() time.Time {
+	return time.Unix(int64(a), 0)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	tests := []struct {
+		name        string
+		items       []at
+		size, slide time.Duration
+		want        []Window[at]
+		err         bool
+	}{
+		{
+			name:  "tumbling",
+			items: []at{0, 3, 9, 10, 25},
+			size:  10 * time.Second,
+			slide: 10 * time.Second,
+			want: []Window[at]{
+				{Start: at(0).time(), End: at(10).time(), Items: []at{0, 3, 9}},
+				{Start: at(10).time(), End: at(20).time(), Items: []at{10}},
+				{Start: at(20).time(), End: at(30).time(), Items: []at{25}},
+			},
+		},
+		{
+			name:  "sliding",
+			items: []at{1, 6, 12},
+			size:  10 * time.Second,
+			slide: 5 * time.Second,
+			want: []Window[at]{
+				{Start: at(-5).time(), End: at(5).time(), Items: []at{1}},
+				{Start: at(0).time(), End: at(10).time(), Items: []at{1, 6}},
+				{Start: at(5).time(), End: at(15).time(), Items: []at{6, 12}},
+				{Start: at(10).time(), End: at(20).time(), Items: []at{12}},
+			},
+		},
+		{
+			name:  "late items are added to the open windows or dropped",
+			items: []at{1, 12, 9, 3},
+			size:  10 * time.Second,
+			slide: 5 * time.Second,
+			want: []Window[at]{
+				{Start: at(-5).time(), End: at(5).time(), Items: []at{1}},
+				{Start: at(0).time(), End: at(10).time(), Items: []at{1}},
+				{Start: at(5).time(), End: at(15).time(), Items: []at{12, 9}},
+				{Start: at(10).time(), End: at(20).time(), Items: []at{12}},
+			},
+		},
+		{
+			name:  "invalid slide",
+			items: []at{1},
+			size:  time.Second,
+			err:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outc, errc := SlidingWindow(context.TODO(), sliceOf(test.items), test.size, test.slide, at.time)
+			var got []Window[at]
+			for window := range outc {
+				got = append(got, window)
+			}
+			assert.Equal(t, test.want, got)
+			assert.Equal(t, test.err, <-errc != nil)
+		})
+	}
+}
+
+func TestTumblingWindow(t *testing.T) {
+	outc, errc := TumblingWindow(context.TODO(), sliceOf([]at{0, 5, 10}), 10*time.Second, at.time)
+	var got []Window[at]
+	for window := range outc {
+		got = append(got, window)
+	}
+	assert.Equal(t, []Window[at]{
+		{Start: at(0).time(), End: at(10).time(), Items: []at{0, 5}},
+		{Start: at(10).time(), End: at(20).time(), Items: []at{10}},
+	}, got)
+	assert.Nil(t, <-errc)
+}
+
+func TestSessionWindow(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []at
+		gap   time.Duration
+		want  []Window[at]
+		err   bool
+	}{
+		{
+			name:  "sessions are closed by the gap",
+			items: []at{0, 3, 8, 20, 21, 32},
+			gap:   5 * time.Second,
+			want: []Window[at]{
+				{Start: at(0).time(), End: at(13).time(), Items: []at{0, 3, 8}},
+				{Start: at(20).time(), End: at(26).time(), Items: []at{20, 21}},
+				{Start: at(32).time(), End: at(37).time(), Items: []at{32}},
+			},
+		},
+		{
+			name:  "an item before the session is added to it",
+			items: []at{10, 8},
+			gap:   5 * time.Second,
+			want: []Window[at]{
+				{Start: at(8).time(), End: at(15).time(), Items: []at{10, 8}},
+			},
+		},
+		{
+			name:  "invalid gap",
+			items: []at{1},
+			err:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outc, errc := SessionWindow(context.TODO(), sliceOf(test.items), test.gap, at.time)
+			var got []Window[at]
+			for window := range outc {
+				got = append(got, window)
+			}
+			assert.Equal(t, test.want, got)
+			assert.Equal(t, test.err, <-errc != nil)
+		})
+	}
+}
+
+func TestWindow_canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	inc := make(chan at, 3)
+	inc <- 0
+	inc <- 20
+	inc <- 40
+	close(inc)
+
+	// nothing is received, so the stage is not blocked on publishing a session
+	outc, errc := SessionWindow(ctx, inc, time.Second, at.time)
+	assert.True(t, errors.Is(<-errc, ErrCanceled))
+	for range outc {
+	}
+}
+
+func TestWindow_invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		stage func(ctx context.Context, inc <-chan at) <-chan error
+	}{
+		{
+			name: "batch size",
+			stage: func(ctx context.Context, inc <-chan at) <-chan error {
+				_, errc := Batch(ctx, inc, 0)
+				return errc
+			},
+		},
+		{
+			name: "window slide",
+			stage: func(ctx context.Context, inc <-chan at) <-chan error {
+				_, errc := SlidingWindow(ctx, inc, time.Second, 0, at.time)
+				return errc
+			},
+		},
+		{
+			name: "session gap",
+			stage: func(ctx context.Context, inc <-chan at) <-chan error {
+				_, errc := SessionWindow(ctx, inc, 0, at.time)
+				return errc
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			goroutines := runtime.NumGoroutine()
+			i := 0
+			inc, _ := Generate(context.Background(), func() (at, bool, error) {
+				if i == 3 {
+					return 0, false, io.EOF
+				}
+				i++
+				return at(i), true, nil
+			})
+
+			// the input is drained, so the previous stage is not blocked even if the context is never canceled
+			assert.NotNil(t, <-test.stage(context.Background(), inc))
+			assertNoLeak(t, goroutines)
+		})
+	}
+}
+
+// sliceOf returns a closed channel of the items
+func sliceOf[T any](items []T) <-chan T {
+	inc := make(chan T, len(items))
+	for _, item := range items {
+		inc <- item
+	}
+	close(inc)
+	return inc
+}
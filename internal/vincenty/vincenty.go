@@ -0,0 +1,60 @@
+package vincenty
+
+import "math"
+
+// WGS-84 ellipsoid
+const (
+	semiMajorAxis = 6378137.0
+	flattening    = 1 / 298.257223563
+	semiMinorAxis = (1 - flattening) * semiMajorAxis
+
+	maxIterations = 200
+	convergence   = 1e-12
+)
+
+// Vincenty calculates the distance in kilometers between two points on the WGS-84 ellipsoid
+// using the inverse formula of Vincenty. It is accurate to within millimeters but slower than haversine.
+// for nearly antipodal points the formula may not converge, in that case the last estimate is returned.
+func Vincenty(lonFrom float64, latFrom float64, lonTo float64, latTo float64) float64 {
+	L := (lonTo - lonFrom) * (math.Pi / 180)
+	U1 := math.Atan((1 - flattening) * math.Tan(latFrom*(math.Pi/180)))
+	U2 := math.Atan((1 - flattening) * math.Tan(latTo*(math.Pi/180)))
+	sinU1, cosU1 := math.Sincos(U1)
+	sinU2, cosU2 := math.Sincos(U2)
+
+	var sinSigma, cosSigma, sigma, cosSqAlpha, cos2SigmaM float64
+	lambda := L
+	for i := 0; i < maxIterations; i++ {
+		sinLambda, cosLambda := math.Sincos(lambda)
+		sinSigma = math.Sqrt((cosU2*sinLambda)*(cosU2*sinLambda) +
+			(cosU1*sinU2-sinU1*cosU2*cosLambda)*(cosU1*sinU2-sinU1*cosU2*cosLambda))
+		if sinSigma == 0 {
+			// coincident points
+			return 0
+		}
+		cosSigma = sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma = math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cosSqAlpha = 1 - sinAlpha*sinAlpha
+		cos2SigmaM = 0
+		if cosSqAlpha != 0 {
+			// otherwise both points are on the equator
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cosSqAlpha
+		}
+		C := flattening / 16 * cosSqAlpha * (4 + flattening*(4-3*cosSqAlpha))
+		prev := lambda
+		lambda = L + (1-C)*flattening*sinAlpha*
+			(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda-prev) < convergence {
+			break
+		}
+	}
+
+	uSq := cosSqAlpha * (semiMajorAxis*semiMajorAxis - semiMinorAxis*semiMinorAxis) / (semiMinorAxis * semiMinorAxis)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+	deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+		B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+
+	return semiMinorAxis * A * (sigma - deltaSigma) / 1000
+}
@@ -0,0 +1,44 @@
+package vincenty
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVincenty(t *testing.T) {
+	tests := []struct {
+		name     string
+		latlangs []float64
+		distance float64
+	}{
+		{
+			name:     "Flinders Peak to Buninyong",
+			latlangs: []float64{144.424867888889, -37.9510334166667, 143.926495527778, -37.6528211388889},
+			distance: 54.972271,
+		},
+		{
+			name:     "10 degrees on the equator",
+			latlangs: []float64{0, 0, 10, 0},
+			distance: 1113.194908,
+		},
+		{
+			name:     "same point",
+			latlangs: []float64{23.730235, 37.967349, 23.730235, 37.967349},
+			distance: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			distance := Vincenty(test.latlangs[0], test.latlangs[1], test.latlangs[2], test.latlangs[3])
+			assert.InDelta(t, test.distance, distance, 0.000001)
+		})
+	}
+}
+
+func BenchmarkVincenty(b *testing.B) {
+	latlangs := []float64{23.730235, 37.967349, 23.730235, 37.967348}
+	for n := 0; n < b.N; n++ {
+		Vincenty(latlangs[0], latlangs[1], latlangs[2], latlangs[3])
+	}
+}
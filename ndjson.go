@@ -0,0 +1,180 @@
+package fare
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// maxNDJSONLine is the maximum length of a line of NDJSON input in bytes
+const maxNDJSONLine = 1024 * 1024
+
+// ndjsonReader reads the lines of NDJSON input, each line is an object with the fields of a position
+// the fields are named by the Schema, id_ride, lat, lng and timestamp by default, and can be numbers or strings
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	fields  []string
+	number  int
+}
+
+// newNDJSONReader creates a ndjsonReader
+func newNDJSONReader(r io.Reader, schema Schema) *ndjsonReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxNDJSONLine)
+
+	field := func(name, defaultName string) string {
+		if name == "" {
+			return defaultName
+		}
+		return name
+	}
+	fields := append([]string{
+		field(schema.RideID, "id_ride"),
+		field(schema.Lat, "lat"),
+		field(schema.Lng, "lng"),
+		field(schema.Timestamp, "timestamp"),
+	}, schema.Extra...)
+
+	return &ndjsonReader{
+		scanner: scanner,
+		fields:  fields,
+	}
+}
+
+// read reads the next object of the input, empty lines are skipped. a line which is not an object
+// with the fields is returned as a malformed record. errors name the line number of the input which caused them
+func (n *ndjsonReader) read() (record, error) {
+	for n.scanner.Scan() {
+		n.number++
+		data := bytes.TrimSpace(n.scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		line := make(Line, len(n.fields))
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(data, &object); err != nil {
+			return record{number: n.number, line: line, malformed: err}, nil
+		}
+
+		var malformed error
+		for i, name := range n.fields {
+			raw, ok := object[name]
+			if !ok {
+				malformed = fmt.Errorf("field %q is missing", name)
+				continue
+			}
+			value, err := jsonValue(raw)
+			if err != nil {
+				malformed = fmt.Errorf("field %q: %w", name, err)
+				continue
+			}
+			line[i] = value
+		}
+
+		return record{number: n.number, line: line, malformed: malformed}, nil
+	}
+
+	if err := n.scanner.Err(); err != nil {
+		return record{}, fmt.Errorf("line %d: %w", n.number+1, err)
+	}
+	return record{}, io.EOF
+}
+
+// jsonValue returns the raw text of a JSON number or the value of a JSON string
+func jsonValue(raw json.RawMessage) (string, error) {
+	if len(raw) > 0 && raw[0] == '"' {
+		var value string
+		err := json.Unmarshal(raw, &value)
+		return value, err
+	}
+
+	var value json.Number
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", fmt.Errorf("should be a number or a string: %s", raw)
+	}
+	return value.String(), nil
+}
+
+// ndjsonFare is the NDJSON output of a ride fare
+type ndjsonFare struct {
+	RideID int               `json:"id_ride"`
+	Trip   int               `json:"trip,omitempty"`
+	Fare   json.Number       `json:"fare"`
+	Extra  map[string]string `json:"extra,omitempty"`
+}
+
+// ndjsonSink writes the fare of rides as NDJSON objects of the form {"id_ride":1,"fare":3.47}
+// the trip field is added if the ride is split into trips, the extra field if the input has extra columns
+type ndjsonSink struct {
+	writer  *bufio.Writer
+	encoder *json.Encoder
+	// extra are the names of the extra columns
+	extra []string
+}
+
+// newNDJSONSink creates a ndjsonSink of the extra columns
+func newNDJSONSink(w io.Writer, extra []string) *ndjsonSink {
+	writer := bufio.NewWriter(w)
+	return &ndjsonSink{writer: writer, encoder: json.NewEncoder(writer), extra: extra}
+}
+
+func (s *ndjsonSink) write(f rideFare) error {
+	return s.encoder.Encode(ndjsonFare{
+		RideID: f.rideId,
+		Trip:   f.trip,
+		Fare:   json.Number(strconv.FormatFloat(float64(f.fare), 'f', 2, 64)),
+		Extra:  extraValues(s.extra, f),
+	})
+}
+
+func (s *ndjsonSink) flush() error {
+	return s.writer.Flush()
+}
+
+// ndjsonQuality is the NDJSON output of the quality report of a ride
+type ndjsonQuality struct {
+	RideID          int            `json:"id_ride"`
+	Parsed          int            `json:"parsed"`
+	RejectedParse   int            `json:"rejected_parse"`
+	RejectedOutlier int            `json:"rejected_outlier"`
+	Used            int            `json:"used"`
+	Reasons         map[string]int `json:"reasons"`
+}
+
+// ndjsonQualitySink writes the quality report of rides as NDJSON objects
+type ndjsonQualitySink struct {
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+// newNDJSONQualitySink creates a ndjsonQualitySink
+func newNDJSONQualitySink(w io.Writer) *ndjsonQualitySink {
+	writer := bufio.NewWriter(w)
+	return &ndjsonQualitySink{writer: writer, encoder: json.NewEncoder(writer)}
+}
+
+func (s *ndjsonQualitySink) write(f rideFare) error {
+	if f.quality == nil {
+		return nil
+	}
+	reasons := f.quality.reasons
+	if reasons == nil {
+		reasons = map[string]int{}
+	}
+	return s.encoder.Encode(ndjsonQuality{
+		RideID:          f.rideId,
+		Parsed:          f.quality.parsed,
+		RejectedParse:   f.quality.rejectedParse,
+		RejectedOutlier: f.quality.rejectedOutlier,
+		Used:            f.quality.used,
+		Reasons:         reasons,
+	})
+}
+
+func (s *ndjsonQualitySink) flush() error {
+	return s.writer.Flush()
+}
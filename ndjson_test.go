@@ -0,0 +1,114 @@
+package fare
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNDJSONReader_read(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  Schema
+		data    string
+		records []record
+		err     string
+	}{
+		{
+			name: "numbers and strings",
+			data: `{"id_ride":1,"lat":37.966660,"lng":23.728308,"timestamp":1405594957}
+
+{"id_ride":"1","lat":"37.966627","lng":23.728263,"timestamp":"2014-07-17T11:02:46Z","speed":12}
+`,
+			records: []record{
+				{number: 1, line: Line{"1", "37.966660", "23.728308", "1405594957"}},
+				{number: 3, line: Line{"1", "37.966627", "23.728263", "2014-07-17T11:02:46Z"}},
+			},
+		},
+		{
+			name:   "field names of the schema and extra fields",
+			schema: Schema{RideID: "ride", Timestamp: "time", Extra: []string{"driver"}},
+			data:   `{"ride":1,"lat":37.966660,"lng":23.728308,"time":1405594957,"driver":"d1"}`,
+			records: []record{
+				{number: 1, line: Line{"1", "37.966660", "23.728308", "1405594957", "d1"}},
+			},
+		},
+		{
+			name: "missing field - malformed",
+			data: `{"id_ride":1,"lat":37.966660,"lng":23.728308,"timestamp":1405594957}
+{"id_ride":1,"lat":37.966660,"lng":23.728308}`,
+			records: []record{
+				{number: 1, line: Line{"1", "37.966660", "23.728308", "1405594957"}},
+				{number: 2, line: Line{"1", "37.966660", "23.728308", ""}, malformed: errors.New(`field "timestamp" is missing`)},
+			},
+		},
+		{
+			name: "not a number or a string - malformed",
+			data: `{"id_ride":1,"lat":[37.966660],"lng":23.728308,"timestamp":1405594957}`,
+			records: []record{
+				{number: 1, line: Line{"1", "", "23.728308", "1405594957"},
+					malformed: fmt.Errorf("field %q: %w", "lat", errors.New("should be a number or a string: [37.966660]"))},
+			},
+		},
+		{
+			name: "too long line - error",
+			data: `{"id_ride":"` + strings.Repeat("1", maxNDJSONLine) + `"}`,
+			err:  `line 1: bufio.Scanner: token too long`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			in := newNDJSONReader(strings.NewReader(test.data), test.schema)
+			var records []record
+			var err error
+			for {
+				var rec record
+				rec, err = in.read()
+				if err != nil {
+					break
+				}
+				records = append(records, rec)
+			}
+			assert.Equal(t, test.records, records)
+			if test.err == "" {
+				assert.Equal(t, io.EOF, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestNDJSONSink(t *testing.T) {
+	out := &bytes.Buffer{}
+	report := &bytes.Buffer{}
+	sinks := []sink{newNDJSONSink(out, nil), newNDJSONQualitySink(report)}
+
+	fares := []rideFare{
+		{rideId: 1, trip: 1, fare: 3.47},
+		{rideId: 1, trip: 2, fare: 12.5, quality: &quality{parsed: 3, rejectedOutlier: 1, used: 2, reasons: map[string]int{"speed is out of range": 1}}},
+		{rideId: 2, fare: 3.47, quality: &quality{parsed: 2, used: 2}},
+	}
+	for _, f := range fares {
+		for _, s := range sinks {
+			assert.Nil(t, s.write(f))
+		}
+	}
+	for _, s := range sinks {
+		assert.Nil(t, s.flush())
+	}
+
+	assert.Equal(t, `{"id_ride":1,"trip":1,"fare":3.47}
+{"id_ride":1,"trip":2,"fare":12.50}
+{"id_ride":2,"fare":3.47}
+`, out.String())
+	assert.Equal(t, `{"id_ride":1,"parsed":3,"rejected_parse":0,"rejected_outlier":1,"used":2,"reasons":{"speed is out of range":1}}
+{"id_ride":2,"parsed":2,"rejected_parse":0,"rejected_outlier":0,"used":2,"reasons":{}}
+`, report.String())
+}
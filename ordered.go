@@ -0,0 +1,12 @@
+package fare
+
+// orderWindowPerWorker is the default number of rides in the order window for each worker
+const orderWindowPerWorker = 4
+
+// orderWindow returns the number of rides which are estimated or held back at once in the ordered mode
+func (c Config) orderWindow() int {
+	if c.OrderWindow == 0 {
+		return c.Concurrency * orderWindowPerWorker
+	}
+	return c.OrderWindow
+}
@@ -0,0 +1,12 @@
+package fare
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_orderWindow(t *testing.T) {
+	assert.Equal(t, 20, Config{Concurrency: 5}.orderWindow())
+	assert.Equal(t, 2, Config{Concurrency: 5, OrderWindow: 2}.orderWindow())
+}
@@ -0,0 +1,274 @@
+package fare
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// defaultMaxOpenPartitions is the number of partition files which are kept open at once
+	defaultMaxOpenPartitions = 32
+	// unknownDate is the date of the partition of the rides without a start
+	unknownDate = "unknown"
+)
+
+// partition is the output file of the fares of a date, it is written to a temporary file
+// which is renamed to the part when all fares are written
+type partition struct {
+	// dir is the directory of the part, it is only created when the part is committed
+	dir        string
+	path, temp string
+	// file and sink are nil while the partition is closed
+	file *os.File
+	sink sink
+	// used is the last time the partition is written, by the number of writes of the partitionedSink
+	used int
+}
+
+// partitionedSink writes the fares into the part files of the dates of their start in UTC,
+// of the form dir/date=YYYY-MM-DD/part-N.csv, where N is the first part which is not in the directory.
+// At most maxOpen files are kept open, the least recently used one is closed to open another one.
+// The temporary files are written in a hidden staging directory of dir, so a failed run leaves no date directories
+type partitionedSink struct {
+	dir string
+	// staging is the directory of the temporary files, it is created by the first write
+	staging    string
+	format     Format
+	columns    []string
+	extra      []string
+	maxOpen    int
+	partitions map[string]*partition
+	open       int
+	writes     int
+}
+
+// newPartitionedSink creates a partitionedSink which writes in the format, which should not be GeoJSON,
+// the columns and extra are the columns of the sinks, see newSink
+func newPartitionedSink(dir string, format Format, columns, extra []string) *partitionedSink {
+	if format == "" {
+		format = FormatCSV
+	}
+	return &partitionedSink{
+		dir:        dir,
+		format:     format,
+		columns:    columns,
+		extra:      extra,
+		maxOpen:    defaultMaxOpenPartitions,
+		partitions: make(map[string]*partition),
+	}
+}
+
+// partitionDate returns the date of the partition of a fare
+func partitionDate(f rideFare) string {
+	start := f.stats.start.Timestamp
+	if start.IsZero() {
+		return unknownDate
+	}
+	return start.UTC().Format("2006-01-02")
+}
+
+func (s *partitionedSink) write(f rideFare) error {
+	p, err := s.partition(partitionDate(f))
+	if err != nil {
+		return err
+	}
+	s.writes++
+	p.used = s.writes
+	return p.sink.write(f)
+}
+
+// partition returns the open partition of the date, creating it if it is new
+func (s *partitionedSink) partition(date string) (*partition, error) {
+	p, ok := s.partitions[date]
+	if !ok {
+		var err error
+		if p, err = s.create(date); err != nil {
+			return nil, err
+		}
+		s.partitions[date] = p
+	}
+	if p.file != nil {
+		return p, nil
+	}
+
+	if s.open >= s.maxOpen {
+		if err := s.closeLeastUsed(); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.OpenFile(p.temp, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	p.file, p.sink = file, newSink(file, s.format, s.columns, s.extra)
+	s.open++
+	return p, nil
+}
+
+// create chooses the part of the partition of the date, its temporary file is in the directory of the date
+// in the staging directory
+func (s *partitionedSink) create(date string) (*partition, error) {
+	if s.staging == "" {
+		if err := os.MkdirAll(s.dir, 0755); err != nil {
+			return nil, err
+		}
+		staging, err := ioutil.TempDir(s.dir, ".staging-")
+		if err != nil {
+			return nil, err
+		}
+		s.staging = staging
+	}
+	staged := filepath.Join(s.staging, "date="+date)
+	if err := os.Mkdir(staged, 0755); err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(s.dir, "date="+date)
+	for n := 0; ; n++ {
+		name := fmt.Sprintf("part-%d.%s", n, s.format)
+		path := filepath.Join(dir, name)
+		if exists(path) {
+			continue
+		}
+		return &partition{dir: dir, path: path, temp: filepath.Join(staged, name)}, nil
+	}
+}
+
+// exists checks if there is a file of the path
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return !os.IsNotExist(err)
+}
+
+// closeLeastUsed closes the open partition which is written least recently
+func (s *partitionedSink) closeLeastUsed() error {
+	var least *partition
+	for _, p := range s.partitions {
+		if p.file != nil && (least == nil || p.used < least.used) {
+			least = p
+		}
+	}
+	if least == nil {
+		return nil
+	}
+	return s.close(least)
+}
+
+// close flushes, syncs and closes the file of an open partition
+func (s *partitionedSink) close(p *partition) error {
+	err := p.sink.flush()
+	if err == nil {
+		err = p.file.Sync()
+	}
+	if cerr := p.file.Close(); err == nil {
+		err = cerr
+	}
+	p.file, p.sink = nil, nil
+	s.open--
+	return err
+}
+
+// flush closes all partitions, they are reopened by the next write
+func (s *partitionedSink) flush() error {
+	for _, p := range s.partitions {
+		if p.file == nil {
+			continue
+		}
+		if err := s.close(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commit publishes the parts of the partitions, which are all written in the staging directory, by renaming them
+// to their directories in the order of their dates, so the parts are only written when all fares are written.
+// If a part can't be published, the parts which are published before are removed along with the directories
+// created for them, and the staging directory is removed. The partitions should be flushed
+func (s *partitionedSink) commit() error {
+	dates := make([]string, 0, len(s.partitions))
+	for date := range s.partitions {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	var published []*partition
+	var created []string
+	for _, date := range dates {
+		p := s.partitions[date]
+		var err error
+		if !exists(p.dir) {
+			if err = os.Mkdir(p.dir, 0755); err == nil {
+				created = append(created, p.dir)
+			}
+		}
+		if err == nil {
+			err = os.Rename(p.temp, p.path)
+		}
+		if err != nil {
+			return s.unpublish(err, published, created)
+		}
+		published = append(published, p)
+	}
+	return s.removeStaging()
+}
+
+// unpublish undoes a commit which failed by err, it removes the published parts and the directories created
+// for them and then the temporary files of the partitions
+func (s *partitionedSink) unpublish(err error, published []*partition, created []string) error {
+	var errs []string
+	for _, p := range published {
+		if rerr := os.Remove(p.path); rerr != nil {
+			errs = append(errs, rerr.Error())
+		}
+	}
+	for i := len(created) - 1; i >= 0; i-- {
+		if rerr := os.Remove(created[i]); rerr != nil {
+			errs = append(errs, rerr.Error())
+		}
+	}
+	if rerr := s.remove(); rerr != nil {
+		errs = append(errs, rerr.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w, unpublish partitions: %s", err, strings.Join(errs, "; "))
+	}
+	return err
+}
+
+// remove closes all partitions and removes their temporary files, the parts are not written
+func (s *partitionedSink) remove() error {
+	var errs []string
+	for _, p := range s.partitions {
+		if p.file != nil {
+			if err := s.close(p); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		if err := os.Remove(p.temp); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err.Error())
+		}
+	}
+	if err := s.removeStaging(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("remove partitions: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// removeStaging removes the staging directory along with the directories of the dates in it, if it is created
+func (s *partitionedSink) removeStaging() error {
+	if s.staging == "" {
+		return nil
+	}
+	if err := os.RemoveAll(s.staging); err != nil {
+		return err
+	}
+	s.staging = ""
+	return nil
+}
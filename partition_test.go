@@ -0,0 +1,226 @@
+package fare
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readPartitions returns the files under a directory by their relative paths,
+// the directories are returned by their paths suffixed by a slash, so the empty ones are seen
+func readPartitions(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		if info.IsDir() {
+			rel, err := filepath.Rel(dir, path)
+			files[filepath.ToSlash(rel)+"/"] = ""
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return err
+	})
+	assert.Nil(t, err)
+	return files
+}
+
+func TestPartitionedSink(t *testing.T) {
+	at := func(date string) rideFare {
+		start, err := time.Parse(time.RFC3339, date)
+		assert.Nil(t, err)
+		return rideFare{stats: stats{start: Position{Timestamp: start}}}
+	}
+	fare := func(id int, fare Price, f rideFare) rideFare {
+		f.rideId, f.fare = id, fare
+		return f
+	}
+
+	tests := []struct {
+		name     string
+		format   Format
+		existing map[string]string
+		fares    []rideFare
+		files    map[string]string
+	}{
+		{
+			name:   "dates in UTC",
+			format: FormatCSV,
+			fares: []rideFare{
+				fare(1, 3.47, at("2014-07-17T10:00:00Z")),
+				fare(2, 5.1, at("2014-07-18T01:00:00+02:00")),
+				fare(3, 4.2, at("2014-07-17T23:00:00Z")),
+				fare(4, 3.47, rideFare{}),
+			},
+			files: map[string]string{
+				"date=2014-07-17/":           "",
+				"date=2014-07-17/part-0.csv": "1,3.47\n2,5.10\n3,4.20\n",
+				"date=unknown/":              "",
+				"date=unknown/part-0.csv":    "4,3.47\n",
+			},
+		},
+		{
+			name:     "existing parts are kept",
+			format:   FormatNDJSON,
+			existing: map[string]string{"date=2014-07-17/part-0.ndjson": "old\n"},
+			fares:    []rideFare{fare(1, 3.47, at("2014-07-17T10:00:00Z"))},
+			files: map[string]string{
+				"date=2014-07-17/":              "",
+				"date=2014-07-17/part-0.ndjson": "old\n",
+				"date=2014-07-17/part-1.ndjson": "{\"id_ride\":1,\"fare\":3.47}\n",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "fare-partition")
+			assert.Nil(t, err)
+			defer os.RemoveAll(dir)
+			for name, data := range test.existing {
+				path := filepath.Join(dir, filepath.FromSlash(name))
+				assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+				assert.Nil(t, ioutil.WriteFile(path, []byte(data), 0644))
+			}
+
+			s := newPartitionedSink(dir, test.format, nil, nil)
+			// a single open file makes every other write reopen its partition
+			s.maxOpen = 1
+			for _, f := range test.fares {
+				assert.Nil(t, s.write(f))
+			}
+			assert.Nil(t, s.flush())
+			assert.Nil(t, s.commit())
+
+			assert.Equal(t, test.files, readPartitions(t, dir))
+		})
+	}
+}
+
+func TestPartitionedSink_remove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fare-partition")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s := newPartitionedSink(dir, FormatCSV, nil, nil)
+	assert.Nil(t, s.write(rideFare{rideId: 1, fare: 3.47}))
+	assert.Nil(t, s.remove())
+
+	assert.Equal(t, map[string]string{}, readPartitions(t, dir))
+}
+
+func TestPartitionedSink_commitFailed(t *testing.T) {
+	at := func(date string) rideFare {
+		start, err := time.Parse(time.RFC3339, date)
+		assert.Nil(t, err)
+		return rideFare{rideId: 1, fare: 3.47, stats: stats{start: Position{Timestamp: start}}}
+	}
+
+	tests := []struct {
+		name  string
+		fares []rideFare
+		// blocking is a file which is written after the fares, so a part can't be published in its place
+		blocking string
+		files    map[string]string
+	}{
+		{
+			name:     "directory of the partition is a file",
+			fares:    []rideFare{{rideId: 1, fare: 3.47}},
+			blocking: "date=unknown",
+			files:    map[string]string{"date=unknown": "file"},
+		},
+		{
+			name:     "rename fails after a part is published",
+			fares:    []rideFare{at("2014-07-17T10:00:00Z"), at("2014-07-18T10:00:00Z")},
+			blocking: "date=2014-07-18/part-0.csv/file",
+			files: map[string]string{
+				"date=2014-07-18/":                "",
+				"date=2014-07-18/part-0.csv/":     "",
+				"date=2014-07-18/part-0.csv/file": "file",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "fare-partition")
+			assert.Nil(t, err)
+			defer os.RemoveAll(dir)
+
+			s := newPartitionedSink(dir, FormatCSV, nil, nil)
+			for _, f := range test.fares {
+				assert.Nil(t, s.write(f))
+			}
+			assert.Nil(t, s.flush())
+			path := filepath.Join(dir, filepath.FromSlash(test.blocking))
+			assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+			assert.Nil(t, ioutil.WriteFile(path, []byte("file"), 0644))
+			assert.NotNil(t, s.commit())
+
+			// the parts published before the failure are removed along with their directories
+			assert.Equal(t, test.files, readPartitions(t, dir))
+		})
+	}
+}
+
+func TestEstimator_Run_partitions(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		conf  Config
+		files map[string]string
+		err   string
+	}{
+		{
+			name: "rides of two dates",
+			data: "1,37.966660,23.728308,1405594957\n1,37.966627,23.728263,1405594966\n" +
+				"2,37.966660,23.728308,1405694957\n2,37.966627,23.728263,1405694966\n",
+			files: map[string]string{
+				"date=2014-07-17/":           "",
+				"date=2014-07-17/part-0.csv": "1,3.47\n",
+				"date=2014-07-18/":           "",
+				"date=2014-07-18/part-0.csv": "2,3.47\n",
+			},
+		},
+		{
+			name:  "failed run writes no parts",
+			data:  "1,37.966660,23.728308,1405594957\n1,37.966627,23.728263,1405594966\n2,a,23.728308,1405594957\n",
+			conf:  Config{InvalidPositions: FailInvalidPositions, ErrorPolicy: ErrorPolicy{Mode: FailFast}},
+			files: map[string]string{},
+			err:   `1 of 2 items failed (fail-fast): line 3: ride 2: latitude "a": invalid latitude`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "fare-partition")
+			assert.Nil(t, err)
+			defer os.RemoveAll(dir)
+
+			options := test.conf
+			options.MaxSpeed, options.Concurrency, options.PartitionDir = 100, 1, dir
+			estimator, err := NewEstimator(strings.NewReader(test.data), ioutil.Discard, &options)
+			assert.Nil(t, err)
+
+			err = estimator.Run(context.TODO())
+			if test.err == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+			assert.Equal(t, test.files, readPartitions(t, dir))
+		})
+	}
+}
@@ -1,12 +1,52 @@
 package fare
 
 import (
+	"errors"
+	"fmt"
+	"math"
 	"strconv"
 	"time"
+)
+
+var (
+	// ErrInvalidRideID is returned when the ride id of a position is not an integer
+	ErrInvalidRideID = errors.New("invalid ride id")
+	// ErrInvalidLatitude is returned when the latitude is not a number in range of [-90, 90]
+	ErrInvalidLatitude = errors.New("invalid latitude")
+	// ErrInvalidLongitude is returned when the longitude is not a number in range of [-180, 180]
+	ErrInvalidLongitude = errors.New("invalid longitude")
+	// ErrInvalidTimestamp is returned when the timestamp is not an epoch time in the valid range
+	ErrInvalidTimestamp = errors.New("invalid timestamp")
+)
 
-	"github.com/cubny/fare/internal/haversine"
+var (
+	// timestamps out of this range are rejected, e.g. devices with reset clocks report 1970
+	minTimestamp = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	maxTimestamp = time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC)
 )
 
+// PositionError describes a field of a position which is invalid
+// it wraps one of the ErrInvalid* errors, use errors.Is to check it
+type PositionError struct {
+	// Line is the line number of the position in the input, 0 if it is not known
+	Line   int
+	RideID string
+	Field  string
+	Value  string
+	Err    error
+}
+
+func (e *PositionError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: ride %s: %s %q: %s", e.Line, e.RideID, e.Field, e.Value, e.Err)
+	}
+	return fmt.Sprintf("ride %s: %s %q: %s", e.RideID, e.Field, e.Value, e.Err)
+}
+
+func (e *PositionError) Unwrap() error {
+	return e.Err
+}
+
 // Position holds the geo coordinates of a ride in specific time
 type Position struct {
 	RideID    int
@@ -14,27 +54,36 @@ type Position struct {
 	Timestamp time.Time
 }
 
-// NewPosition creates a Position out of a tuple of strings
+// NewPosition creates a Position out of a tuple of strings, the format of the timestamp is detected
+// it returns a *PositionError if any of the values is malformed or out of range
 func NewPosition(rawRideID, rawLat, rawLong, rawTimestamp string) (Position, error) {
+	return newPosition(rawRideID, rawLat, rawLong, rawTimestamp, TimestampAuto)
+}
+
+// newPosition creates a Position out of a tuple of strings with a timestamp of the given format
+func newPosition(rawRideID, rawLat, rawLong, rawTimestamp string, format TimestampFormat) (Position, error) {
+	invalid := func(field, value string, err error) error {
+		return &PositionError{RideID: rawRideID, Field: field, Value: value, Err: err}
+	}
+
 	rideId, err := strconv.Atoi(rawRideID)
 	if err != nil {
-		return Position{}, err
+		return Position{}, invalid("ride id", rawRideID, ErrInvalidRideID)
 	}
 
 	lat, err := strconv.ParseFloat(rawLat, 6)
-	if err != nil {
-		return Position{}, err
+	if err != nil || !validLatitude(lat) {
+		return Position{}, invalid("latitude", rawLat, ErrInvalidLatitude)
 	}
 
 	long, err := strconv.ParseFloat(rawLong, 6)
-	if err != nil {
-		return Position{}, err
+	if err != nil || !validLongitude(long) {
+		return Position{}, invalid("longitude", rawLong, ErrInvalidLongitude)
 	}
-	ti, err := strconv.ParseInt(rawTimestamp, 10, 64)
-	if err != nil {
-		return Position{}, err
+	timestamp, err := ParseTimestamp(rawTimestamp, format)
+	if err != nil || !validTimestamp(timestamp) {
+		return Position{}, invalid("timestamp", rawTimestamp, ErrInvalidTimestamp)
 	}
-	timestamp := time.Unix(ti, 0)
 
 	return Position{
 		RideID:    rideId,
@@ -44,7 +93,26 @@ func NewPosition(rawRideID, rawLat, rawLong, rawTimestamp string) (Position, err
 	}, nil
 }
 
-// Distance returns the haversine distance of the given position
-func (p Position) Distance(from Position) float64 {
-	return haversine.Haversine(from.Long, from.Lat, p.Long, p.Lat)
+// validLatitude checks if the latitude is in range of [-90, 90]
+func validLatitude(lat float64) bool {
+	return !math.IsNaN(lat) && lat >= -90 && lat <= 90
+}
+
+// validLongitude checks if the longitude is in range of [-180, 180]
+func validLongitude(long float64) bool {
+	return !math.IsNaN(long) && long >= -180 && long <= 180
+}
+
+// validTimestamp checks if the timestamp is in range of [minTimestamp, maxTimestamp)
+func validTimestamp(t time.Time) bool {
+	return !t.Before(minTimestamp) && t.Before(maxTimestamp)
+}
+
+// Distance returns the distance of the given position using the distance model
+// if distance is nil, the haversine distance is returned
+func (p Position) Distance(from Position, distance DistanceFunc) float64 {
+	if distance == nil {
+		distance = Haversine
+	}
+	return distance(from, p)
 }
@@ -1,6 +1,7 @@
 package fare
 
 import (
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"testing"
 	"time"
@@ -11,6 +12,7 @@ func TestNewPosition(t *testing.T) {
 		name     string
 		tuple    []string
 		hasError bool
+		err      error
 	}{
 		{
 			name:     "ok",
@@ -21,21 +23,43 @@ func TestNewPosition(t *testing.T) {
 			name:     "wrong rideId - error",
 			tuple:    []string{"a", "37.942437", "23.642862", "1405595819"},
 			hasError: true,
+			err:      ErrInvalidRideID,
 		},
 		{
 			name:     "wrong lat - error",
 			tuple:    []string{"1", "a", "23.642862", "1405595819"},
 			hasError: true,
+			err:      ErrInvalidLatitude,
+		},
+		{
+			name:     "lat out of range - error",
+			tuple:    []string{"1", "500", "23.642862", "1405595819"},
+			hasError: true,
+			err:      ErrInvalidLatitude,
 		},
 		{
 			name:     "wrong long - error",
 			tuple:    []string{"1", "37.942437", "a", "1405595819"},
 			hasError: true,
+			err:      ErrInvalidLongitude,
+		},
+		{
+			name:     "long out of range - error",
+			tuple:    []string{"1", "37.942437", "-180.5", "1405595819"},
+			hasError: true,
+			err:      ErrInvalidLongitude,
 		},
 		{
 			name:     "wrong time - error",
 			tuple:    []string{"1", "37.942437", "23.642862", "a"},
 			hasError: true,
+			err:      ErrInvalidTimestamp,
+		},
+		{
+			name:     "time in 1970 - error",
+			tuple:    []string{"1", "37.942437", "23.642862", "3600"},
+			hasError: true,
+			err:      ErrInvalidTimestamp,
 		},
 	}
 
@@ -43,6 +67,12 @@ func TestNewPosition(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			_, err := NewPosition(test.tuple[0], test.tuple[1], test.tuple[2], test.tuple[3])
 			assert.Equal(t, test.hasError, err != nil)
+			if test.err != nil {
+				assert.True(t, errors.Is(err, test.err))
+				var perr *PositionError
+				assert.True(t, errors.As(err, &perr))
+				assert.Equal(t, test.tuple[0], perr.RideID)
+			}
 		})
 	}
 }
@@ -60,8 +90,11 @@ func TestPosition_Distance(t *testing.T) {
 		Long:      23.728263,
 		Timestamp: time.Unix(1405594966, 0),
 	}
-	distance := p11.Distance(p12)
+	distance := p11.Distance(p12, nil)
 	assert.Equal(t, 0.005387608950290441, distance)
+	assert.Equal(t, distance, p11.Distance(p12, Haversine))
+	assert.InDelta(t, 0.00539005, p11.Distance(p12, Vincenty), 1e-8)
+	assert.InDelta(t, distance, p11.Distance(p12, Equirectangular), 1e-12)
 }
 
 func BenchmarkNewPosition(b *testing.B) {
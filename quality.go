@@ -0,0 +1,71 @@
+package fare
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// quality holds the data quality counters of a ride
+// parsed + rejectedParse is the number of lines of the ride and
+// used + rejectedOutlier is the number of parsed positions
+type quality struct {
+	parsed          int
+	rejectedParse   int
+	rejectedOutlier int
+	used            int
+	// reasons counts the rejected lines and positions by the reason of rejection
+	reasons map[string]int
+}
+
+// reject counts the rejections of the given reason
+func (q *quality) reject(reason string, count int) {
+	if q.reasons == nil {
+		q.reasons = make(map[string]int)
+	}
+	q.reasons[reason] += count
+}
+
+// record returns the quality counters as a csv record of the form
+// id_ride, parsed, rejected_parse, rejected_outlier, used, reasons
+func (q quality) record(rideId int) Line {
+	return Line{
+		strconv.Itoa(rideId),
+		strconv.Itoa(q.parsed),
+		strconv.Itoa(q.rejectedParse),
+		strconv.Itoa(q.rejectedOutlier),
+		strconv.Itoa(q.used),
+		q.formatReasons(),
+	}
+}
+
+// formatReasons formats the reasons as reason=count pairs separated by semicolons, sorted by reason
+func (q quality) formatReasons() string {
+	reasons := make([]string, 0, len(q.reasons))
+	for reason := range q.reasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	pairs := make([]string, 0, len(reasons))
+	for _, reason := range reasons {
+		pairs = append(pairs, reason+"="+strconv.Itoa(q.reasons[reason]))
+	}
+	return strings.Join(pairs, ";")
+}
+
+// reasonOf returns the reason of a rejection
+// for a PositionError it is the wrapped error, so the same reasons are counted together regardless of the value,
+// and for a malformed line it is the reason without the line number
+func reasonOf(err error) string {
+	var perr *PositionError
+	if errors.As(err, &perr) {
+		return perr.Err.Error()
+	}
+	var lerr *lineError
+	if errors.As(err, &lerr) {
+		return lerr.err.Error()
+	}
+	return err.Error()
+}
@@ -3,7 +3,10 @@ package fare
 import (
 	"context"
 	"errors"
+	"io"
 	"math"
+	"strconv"
+	"sync"
 
 	"github.com/cubny/fare/internal/pipeline"
 )
@@ -15,13 +18,45 @@ var ErrLinesEmpty = errors.New("ride lines are empty")
 type ride struct {
 	rideId int
 	lines  []Line
-	conf   *Config
+	// numbers are the line numbers of the lines in the input, if they are known
+	numbers []int
+	// parsed are the positions of the lines which are nil, as they are parsed by the source
+	parsed []Position
+	// malformed are the reasons why the lines could not be read by the source, nil for the lines which are read
+	malformed []error
+	// keepSegments keeps the segments of the trips in their fares
+	keepSegments bool
+	conf         *Config
+
+	// mu guards rideId, quality and rejected which are updated by different stages of the pipeline
+	mu      sync.Mutex
+	quality quality
+	// rejected are the dead letters of the rejected lines
+	rejected []deadLetter
 }
 
-// rideFare is the result of ride pipeline
+// rideFare is the result of ride pipeline, one for each trip of the ride
 type rideFare struct {
 	rideId int
-	fare   Price
+	// trip is the number of the trip starting from 1 if the ride is split into trips, otherwise 0
+	trip int
+	fare Price
+	// quality is the data quality of the whole ride, it is only set on the last trip of the ride
+	quality *quality
+	// stats are the statistics of the trip
+	stats stats
+	// segments are the segments of the trip, they are only kept if the ride keeps them
+	segments []Segment
+	// extra are the values of the extra columns of the schema in the first line of the ride
+	extra []string
+}
+
+// id returns the id of the ride suffixed by the number of the trip if the ride is split into trips
+func (f rideFare) id() string {
+	if f.trip == 0 {
+		return strconv.Itoa(f.rideId)
+	}
+	return strconv.Itoa(f.rideId) + "_" + strconv.Itoa(f.trip)
 }
 
 // newRide creates a ride
@@ -29,6 +64,9 @@ func newRide(lines []Line, conf *Config) (*ride, error) {
 	if err := conf.Validate(); err != nil {
 		return nil, err
 	}
+	if len(lines) == 0 {
+		return nil, ErrLinesEmpty
+	}
 
 	return &ride{
 		lines: lines,
@@ -36,80 +74,230 @@ func newRide(lines []Line, conf *Config) (*ride, error) {
 	}, nil
 }
 
-// run carries out the ride pipeline to estimate the ride fare
-func (r *ride) run(ctx context.Context, outc chan<- pipeline.Event) error {
-	positions, errc := pipeline.Generate(ctx, r.positions)
-	segments, errc1 := pipeline.Reduce(ctx, positions, r.segments)
-	total, err := r.fare(ctx, segments)
+// run carries out the ride pipeline to estimate the fare of each trip of the ride
+func (r *ride) run(ctx context.Context, outc chan<- event) error {
+	fares, err := r.estimate(ctx)
 	if err != nil {
 		return err
 	}
 
-	errm := pipeline.MergeErrors(ctx, errc, errc1)
-	for err := range errm {
-		switch {
-		case err == ErrLinesEmpty:
-		case err != nil:
-			return err
+	for _, fare := range fares {
+		select {
+		case <-ctx.Done():
+			return nil
+		case outc <- fare:
+		}
+	}
+
+	return nil
+}
+
+// estimate estimates the fare of each trip of the ride, a ride of malformed lines only has no fares
+func (r *ride) estimate(ctx context.Context) ([]rideFare, error) {
+	malformedOnly, extra := r.malformedOnly(), r.extra()
+	runner := pipeline.NewRunner(ctx)
+	positionc, errc := pipeline.Generate(pipeline.Stage(runner.Context(), "parse positions"), r.positions)
+	runner.Add(errc)
+	var positions []Position
+	err := runner.Run(func(ctx context.Context) error {
+		return pipeline.Sink(pipeline.Stage(ctx, "collect positions"), positionc, func(val Position) error {
+			positions = append(positions, val)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if malformedOnly {
+		return nil, nil
+	}
+
+	trips := r.splitTrips(positions)
+	fares := make([]rideFare, 0, len(trips))
+	for i, trip := range trips {
+		fare, err := r.runTrip(ctx, trip)
+		if err != nil {
+			return nil, err
+		}
+		if len(trips) > 1 {
+			fare.trip = i + 1
 		}
+		fare.extra = extra
+		fares = append(fares, fare)
 	}
 
-	select {
-	case <-ctx.Done():
-	case outc <- total:
+	r.mu.Lock()
+	q := r.quality
+	r.mu.Unlock()
+	q.used = q.parsed - q.rejectedOutlier
+	fares[len(fares)-1].quality = &q
+
+	return fares, nil
+}
+
+// runTrip carries out the trip pipeline which reduces the positions of a trip to segments and estimates its fare
+func (r *ride) runTrip(ctx context.Context, positions []Position) (rideFare, error) {
+	runner := pipeline.NewRunner(ctx)
+	positionc, errc := pipeline.Generate(pipeline.Stage(runner.Context(), "trip positions"), generatePositions(positions))
+	segments, errc1 := pipeline.Reduce(pipeline.Stage(runner.Context(), "segments"), positionc, r.segments)
+	runner.Add(errc, errc1)
+	var total rideFare
+	err := runner.Run(func(ctx context.Context) error {
+		var err error
+		total, err = r.fare(ctx, segments)
+		return err
+	})
+	if err != nil {
+		return rideFare{}, err
 	}
 
-	return nil
+	if total.stats.segments == 0 && len(positions) > 0 {
+		// the trip has no segments, it starts and ends at its first position
+		total.stats.start, total.stats.end = positions[0], positions[0]
+	}
+
+	return total, nil
 }
 
-// positions is a pipeline.generateFunc which generates a stream of positions based on lines
-func (r *ride) positions() (interface{}, error) {
+// positions is a pipeline.generateFunc which generates a stream of positions based on lines, it returns io.EOF
+// when all lines are parsed
+func (r *ride) positions() (Position, bool, error) {
 	if len(r.lines) == 0 {
-		return nil, ErrLinesEmpty
+		return Position{}, false, io.EOF
 	}
 
-	line := r.unshiftLines()
-	position, err := NewPosition(line[0], line[1], line[2], line[3])
+	number, line, position, malformed := r.unshiftLines()
+	var err error
+	switch {
+	case malformed != nil:
+		err = &lineError{line: number, err: malformed}
+	case line != nil:
+		position, err = newPosition(line[0], line[1], line[2], line[3], r.conf.TimestampFormat)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if err != nil {
-		return nil, nil
+		var perr *PositionError
+		if errors.As(err, &perr) {
+			perr.Line = number
+		}
+		r.rejected = append(r.rejected, deadLetter{
+			kind:   deadLetterLine,
+			line:   number,
+			rideID: record{line: line}.rideID(),
+			reason: lineReason(err),
+			data:   line,
+		})
+		if r.conf.InvalidPositions == FailInvalidPositions {
+			return Position{}, false, err
+		}
+		// erroneous line will be skipped
+		r.quality.rejectedParse++
+		r.quality.reject(reasonOf(err), 1)
+		return Position{}, false, nil
+	}
+
+	if r.quality.parsed == 0 {
+		r.rideId = position.RideID
 	}
+	r.quality.parsed++
 
-	return position, nil
+	return position, true, nil
 }
 
 // segments is a pipeline.reduceFunc which reduces two consecutive positions into a segment
-func (r *ride) segments(item1 interface{}, last interface{}) (interface{}, error) {
-	p1 := item1.(Position)
-	p2 := last.(Position)
-	seg, err := NewSegment(p1, p2, r.conf.MaxSpeed)
+func (r *ride) segments(p1 Position, p2 Position) (Segment, bool, error) {
+	seg, err := NewSegment(p1, p2, r.conf.MaxSpeed, r.conf.Distance)
 	if err != nil {
-		// erroneous segment will be skipped
-		return nil, nil
+		// erroneous segment will be skipped and so its last position
+		r.mu.Lock()
+		r.quality.rejectedOutlier++
+		r.quality.reject(reasonOf(err), 1)
+		r.mu.Unlock()
+		return Segment{}, false, nil
 	}
-	return seg, nil
+	return seg, true, nil
 }
 
-// fare calculates the total sum of the ride fare estimation
+// fare calculates the total sum of the trip fare estimation
 // fare is the sink of the ride pipeline
-func (r *ride) fare(ctx context.Context, segments <-chan pipeline.Event) (rideFare, error) {
+func (r *ride) fare(ctx context.Context, segments <-chan Segment) (rideFare, error) {
 	totalFare := Price(fareFlag)
-	rideId := 0
-	err := pipeline.Sink(ctx, segments, func(val interface{}) error {
-		item := val.(Segment)
+	var tripStats stats
+	var tripSegments []Segment
+	err := pipeline.Sink(pipeline.Stage(ctx, "fare"), segments, func(item Segment) error {
 		totalFare += item.Fare()
-		rideId = item.rideID
+		tripStats.add(item)
+		if r.keepSegments {
+			tripSegments = append(tripSegments, item)
+		}
 		return nil
 	})
 
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	return rideFare{
-		rideId: rideId,
-		fare:   Price(math.Max(float64(totalFare), fareMinimum)),
+		rideId:   r.rideId,
+		fare:     Price(math.Max(float64(totalFare), fareMinimum)),
+		stats:    tripStats,
+		segments: tripSegments,
 	}, err
 }
 
-// unshiftLines unshifts a member from ride's lines
-func (r *ride) unshiftLines() Line {
+// malformedOnly tells if all lines of the ride are malformed, so they are not a ride
+func (r *ride) malformedOnly() bool {
+	if len(r.lines) == 0 || len(r.malformed) < len(r.lines) {
+		return false
+	}
+	for _, err := range r.malformed {
+		if err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// extra returns the values of the extra columns of the first line of the ride which is not malformed,
+// the extra columns follow the ride id, lat, lng and timestamp of the line
+func (r *ride) extra() []string {
+	for i, line := range r.lines {
+		if i < len(r.malformed) && r.malformed[i] != nil || len(line) <= 4 {
+			continue
+		}
+		return line[4:]
+	}
+	return nil
+}
+
+// unshiftLines unshifts a member from ride's lines along with its line number, which is 0 if it is not known,
+// its parsed position if the line is nil and the reason why it is malformed if it is
+func (r *ride) unshiftLines() (int, Line, Position, error) {
 	line, lines := r.lines[0], r.lines[1:]
 	r.lines = lines
-	return line
+	number := 0
+	if len(r.numbers) > 0 {
+		number, r.numbers = r.numbers[0], r.numbers[1:]
+	}
+	var position Position
+	if len(r.parsed) > 0 {
+		position, r.parsed = r.parsed[0], r.parsed[1:]
+	}
+	var malformed error
+	if len(r.malformed) > 0 {
+		malformed, r.malformed = r.malformed[0], r.malformed[1:]
+	}
+	return number, line, position, malformed
+}
+
+// generatePositions returns a pipeline.generateFunc which generates a stream of the given positions
+func generatePositions(positions []Position) func() (Position, bool, error) {
+	return func() (Position, bool, error) {
+		if len(positions) == 0 {
+			return Position{}, false, io.EOF
+		}
+		position := positions[0]
+		positions = positions[1:]
+		return position, true, nil
+	}
 }
@@ -2,7 +2,7 @@ package fare
 
 import (
 	"context"
-	"github.com/cubny/fare/internal/pipeline"
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"log"
 	"testing"
@@ -24,7 +24,7 @@ func TestRideEstimator_run(t *testing.T) {
 	estimator, err := newRide(lines, config)
 	assert.Nil(t, err)
 
-	outc := make(chan pipeline.Event, 1)
+	outc := make(chan event, 1)
 	err = estimator.run(context.TODO(), outc)
 	assert.Nil(t, err)
 
@@ -36,3 +36,57 @@ func TestRideEstimator_run(t *testing.T) {
 	close(outc)
 
 }
+
+func TestRideEstimator_run_quality(t *testing.T) {
+	config := &Config{
+		MaxSpeed:    100,
+		Concurrency: 1,
+	}
+	lines := []Line{
+		{"1", "37.966660", "23.728308", "1405594957"},
+		{"1", "a", "23.728263", "1405594966"},
+		{"1", "37.966625", "23.728263", "1405594974"},
+		{"1", "38.966613", "23.728375", "1405594984"},
+		{"1", "37.966203", "23.728597", "1405594992"},
+	}
+
+	estimator, err := newRide(lines, config)
+	assert.Nil(t, err)
+
+	outc := make(chan event, 1)
+	err = estimator.run(context.TODO(), outc)
+	assert.Nil(t, err)
+
+	rideFare := (<-outc).(rideFare)
+	assert.Equal(t, 1, rideFare.rideId)
+	assert.Equal(t, 4, rideFare.quality.parsed)
+	assert.Equal(t, 1, rideFare.quality.rejectedParse)
+	assert.Equal(t, 1, rideFare.quality.rejectedOutlier)
+	assert.Equal(t, 3, rideFare.quality.used)
+	assert.Equal(t, 1, rideFare.quality.reasons[ErrSpeedOutOfRange.Error()])
+	close(outc)
+}
+
+func TestRideEstimator_run_failInvalidPositions(t *testing.T) {
+	config := &Config{
+		MaxSpeed:         100,
+		Concurrency:      1,
+		InvalidPositions: FailInvalidPositions,
+	}
+	lines := []Line{
+		{"1", "37.966660", "23.728308", "1405594957"},
+		{"1", "500", "23.728263", "1405594966"},
+		{"1", "37.966625", "23.728263", "1405594974"},
+	}
+
+	estimator, err := newRide(lines, config)
+	assert.Nil(t, err)
+	estimator.numbers = []int{2, 3, 4}
+
+	outc := make(chan event, 1)
+	err = estimator.run(context.TODO(), outc)
+	assert.True(t, errors.Is(err, ErrInvalidLatitude))
+	assert.EqualError(t, err, `line 3: ride 1: latitude "500": invalid latitude`)
+	assert.Equal(t, 0, len(outc))
+	close(outc)
+}
@@ -0,0 +1,241 @@
+package fare
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Schema describes the layout of the input CSV
+// for NDJSON input only the columns are used, as the field names of the objects
+type Schema struct {
+	// Header tells that the first line of the input is a header which names the columns
+	Header bool
+	// RideID, Lat, Lng and Timestamp are the columns of a position, either a column name of the header
+	// or a 0-based column index. Empty means the columns 0, 1, 2 and 3 in order
+	RideID, Lat, Lng, Timestamp string
+	// Extra are the additional columns which are written along with the fares, by their values in the first line
+	// of the ride
+	Extra []string
+	// Comma is the field delimiter, 0 means ','
+	Comma rune
+	// Comment is the character which starts a comment line, 0 means there are no comments
+	Comment rune
+}
+
+// plain tells if the schema is the four columns of ride id, lat, lng and timestamp separated by commas,
+// without a header and comments
+func (s Schema) plain() bool {
+	return !s.Header && s.RideID == "" && s.Lat == "" && s.Lng == "" && s.Timestamp == "" &&
+		len(s.Extra) == 0 && (s.Comma == 0 || s.Comma == ',') && s.Comment == 0
+}
+
+// Validate checks the delimiters of the schema
+func (s Schema) Validate() error {
+	invalid := func(r rune) bool {
+		return r == '"' || r == '\r' || r == '\n'
+	}
+	switch {
+	case invalid(s.Comma) || invalid(s.Comment):
+		return errors.New("schema delimiters should not be a quote or a line break")
+	case s.Comment != 0 && (s.Comment == s.Comma || s.Comment == ',' && s.Comma == 0):
+		return errors.New("schema comment character should be different from the delimiter")
+	}
+	return nil
+}
+
+// record is a line of the input along with its line number
+// the line is nil if the source has already parsed it into the position
+type record struct {
+	number   int
+	line     Line
+	position Position
+	// offset is the byte offset of the input after the line, it is only known for CSV input
+	offset int64
+	// malformed is the reason why the line could not be read into the columns of the schema, nil if it is read.
+	// the columns of a malformed line which are not read are empty
+	malformed error
+}
+
+// rideID returns the raw ride id of the record
+func (r record) rideID() string {
+	if r.line == nil {
+		return strconv.Itoa(r.position.RideID)
+	}
+	if len(r.line) == 0 {
+		return ""
+	}
+	return r.line[0]
+}
+
+// unknownRide tells if the ride of the record is unknown, which is a malformed line without a ride id
+func (r record) unknownRide() bool {
+	return r.malformed != nil && r.rideID() == ""
+}
+
+// sameRide tells if the records belong to the same ride
+func (r record) sameRide(other record) bool {
+	if r.line == nil && other.line == nil {
+		return r.position.RideID == other.position.RideID
+	}
+	return r.rideID() == other.rideID()
+}
+
+// csvReader reads the lines of the input CSV as laid out by the Schema and normalizes them
+// to the order of ride id, lat, lng and timestamp followed by the extra columns
+type csvReader struct {
+	reader *csv.Reader
+	schema Schema
+	// columns are the indexes of the normalized columns in the input, nil until the header is read
+	columns []int
+	// identity tells the input is already normalized
+	identity bool
+	// fields is the number of fields of a line, which is the number of columns of the header or
+	// of the first line which has all columns, 0 until it is known
+	fields int
+}
+
+// lineError describes a line of the input which could not be read into the columns of the schema
+type lineError struct {
+	line int
+	err  error
+}
+
+func (e *lineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.err)
+}
+
+func (e *lineError) Unwrap() error {
+	return e.err
+}
+
+// newCSVReader creates a csvReader
+func newCSVReader(r io.Reader, schema Schema) *csvReader {
+	in := csv.NewReader(r)
+	if schema.Comma != 0 {
+		in.Comma = schema.Comma
+	}
+	in.Comment = schema.Comment
+	// the number of fields is checked by the csvReader, so a malformed line does not set it
+	in.FieldsPerRecord = -1
+
+	return &csvReader{
+		reader: in,
+		schema: schema,
+	}
+}
+
+// read reads the next line of the input, a line which can not be read into the columns of the schema
+// is returned as a malformed record. errors name the line number of the input which caused them
+func (c *csvReader) read() (record, error) {
+	if c.columns == nil {
+		if err := c.resolveColumns(); err != nil {
+			return record{}, err
+		}
+	}
+
+	fields, err := c.reader.Read()
+	var perr *csv.ParseError
+	if errors.As(err, &perr) {
+		return c.malformed(perr.StartLine, nil, perr.Err), nil
+	}
+	if err != nil {
+		return record{}, err
+	}
+	number, _ := c.reader.FieldPos(0)
+
+	if c.fields > 0 && len(fields) != c.fields {
+		return c.malformed(number, fields, csv.ErrFieldCount), nil
+	}
+
+	offset := c.reader.InputOffset()
+
+	if c.identity && len(fields) == len(c.columns) {
+		return record{number: number, line: fields, offset: offset}, nil
+	}
+
+	line := make(Line, len(c.columns))
+	for i, column := range c.columns {
+		if column >= len(fields) {
+			return c.malformed(number, fields, fmt.Errorf("expected at least %d columns, got %d", column+1, len(fields))), nil
+		}
+		line[i] = fields[column]
+	}
+	if c.fields == 0 {
+		c.fields = len(fields)
+	}
+
+	return record{number: number, line: line, offset: offset}, nil
+}
+
+// malformed returns the record of a malformed line with the columns which are in its fields
+func (c *csvReader) malformed(number int, fields []string, err error) record {
+	line := make(Line, len(c.columns))
+	for i, column := range c.columns {
+		if column < len(fields) {
+			line[i] = fields[column]
+		}
+	}
+	return record{number: number, line: line, offset: c.reader.InputOffset(), malformed: err}
+}
+
+// resolveColumns resolves the columns of the schema into column indexes, reading the header if there is one
+func (c *csvReader) resolveColumns() error {
+	var header map[string]int
+	headerLine := 0
+	if c.schema.Header {
+		fields, err := c.reader.Read()
+		if err != nil {
+			return fmt.Errorf("read header: %w", err)
+		}
+		headerLine, _ = c.reader.FieldPos(0)
+		header = make(map[string]int, len(fields))
+		for i, name := range fields {
+			header[name] = i
+		}
+		c.fields = len(fields)
+	}
+
+	refs := append([]string{c.schema.RideID, c.schema.Lat, c.schema.Lng, c.schema.Timestamp}, c.schema.Extra...)
+	columns := make([]int, len(refs))
+	identity := true
+	for i, ref := range refs {
+		column, err := resolveColumn(ref, i, header)
+		if err != nil && header != nil {
+			return fmt.Errorf("line %d: %w", headerLine, err)
+		}
+		if err != nil {
+			return err
+		}
+		columns[i] = column
+		identity = identity && column == i
+	}
+
+	c.columns = columns
+	c.identity = identity
+	if identity && header == nil {
+		c.fields = len(columns)
+	}
+	return nil
+}
+
+// resolveColumn resolves a column reference of the schema to a column index
+// an empty reference resolves to the given default index
+func resolveColumn(ref string, defaultIndex int, header map[string]int) (int, error) {
+	if ref == "" {
+		return defaultIndex, nil
+	}
+	if column, ok := header[ref]; ok {
+		return column, nil
+	}
+	column, err := strconv.Atoi(ref)
+	if err != nil || column < 0 {
+		if header != nil {
+			return 0, fmt.Errorf("column %q is not in the header", ref)
+		}
+		return 0, fmt.Errorf("column %q is not a column index and the input has no header", ref)
+	}
+	return column, nil
+}
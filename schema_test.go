@@ -0,0 +1,122 @@
+package fare
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSVReader_read(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  Schema
+		data    string
+		records []record
+		err     string
+	}{
+		{
+			name: "default schema",
+			data: "1,37.966660,23.728308,1405594957\n1,37.966627,23.728263,1405594966\n",
+			records: []record{
+				{number: 1, line: Line{"1", "37.966660", "23.728308", "1405594957"}, offset: 33},
+				{number: 2, line: Line{"1", "37.966627", "23.728263", "1405594966"}, offset: 66},
+			},
+		},
+		{
+			name: "header with named columns, delimiter, comments and extra columns",
+			schema: Schema{
+				Header:    true,
+				RideID:    "ride",
+				Lat:       "latitude",
+				Lng:       "longitude",
+				Timestamp: "time",
+				Extra:     []string{"driver"},
+				Comma:     ';',
+				Comment:   '#',
+			},
+			data: "# exported rides\ntime;driver;longitude;latitude;ride\n1405594957;d1;23.728308;37.966660;1\n# pause\n1405594966;d1;23.728263;37.966627;1\n",
+			records: []record{
+				{number: 3, line: Line{"1", "37.966660", "23.728308", "1405594957", "d1"}, offset: 89},
+				{number: 5, line: Line{"1", "37.966627", "23.728263", "1405594966", "d1"}, offset: 133},
+			},
+		},
+		{
+			name:   "column indexes without header",
+			schema: Schema{RideID: "3", Lat: "2", Lng: "1", Timestamp: "0"},
+			data:   "1405594957,23.728308,37.966660,1\n",
+			records: []record{
+				{number: 1, line: Line{"1", "37.966660", "23.728308", "1405594957"}, offset: 33},
+			},
+		},
+		{
+			name:   "column is not in the header - error",
+			schema: Schema{Header: true, RideID: "ride"},
+			data:   "id,lat,lng,timestamp\n1,37.966660,23.728308,1405594957\n",
+			err:    `line 1: column "ride" is not in the header`,
+		},
+		{
+			name:   "column name without header - error",
+			schema: Schema{RideID: "ride"},
+			data:   "1,37.966660,23.728308,1405594957\n",
+			err:    `column "ride" is not a column index and the input has no header`,
+		},
+		{
+			name:   "wrong number of fields - malformed",
+			schema: Schema{Timestamp: "5"},
+			data:   "1,37.966660,23.728308,1405594957,x,1405594957\n1,37.966660,23.728308,1405594957,x\n",
+			records: []record{
+				{number: 1, line: Line{"1", "37.966660", "23.728308", "1405594957"}, offset: 46},
+				{number: 2, line: Line{"1", "37.966660", "23.728308", ""}, offset: 81, malformed: csv.ErrFieldCount},
+			},
+		},
+		{
+			name:   "missing column - malformed",
+			schema: Schema{Timestamp: "5"},
+			data:   "1,37.966660\n",
+			records: []record{
+				{number: 1, line: Line{"1", "37.966660", "", ""}, offset: 12, malformed: errors.New("expected at least 3 columns, got 2")},
+			},
+		},
+		{
+			name: "bare quote - malformed",
+			data: "1,a\"b,23.728308,1405594957\n1,37.966660,23.728308,1405594957\n",
+			records: []record{
+				{number: 1, line: Line{"", "", "", ""}, offset: 27, malformed: csv.ErrBareQuote},
+				{number: 2, line: Line{"1", "37.966660", "23.728308", "1405594957"}, offset: 60},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			in := newCSVReader(strings.NewReader(test.data), test.schema)
+			var records []record
+			var err error
+			for {
+				var rec record
+				rec, err = in.read()
+				if err != nil {
+					break
+				}
+				records = append(records, rec)
+			}
+			assert.Equal(t, test.records, records)
+			if test.err == "" {
+				assert.Equal(t, io.EOF, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestSchema_Validate(t *testing.T) {
+	assert.Nil(t, Schema{Comma: ';', Comment: '#'}.Validate())
+	assert.NotNil(t, Schema{Comma: '"'}.Validate())
+	assert.NotNil(t, Schema{Comment: ','}.Validate())
+	assert.NotNil(t, Schema{Comma: ';', Comment: ';'}.Validate())
+}
@@ -5,9 +5,27 @@ import (
 	"time"
 )
 
+var (
+	// ErrRideMismatch is returned when the positions of a segment belong to different rides
+	ErrRideMismatch = errors.New("ride is not the same")
+	// ErrSpeedOutOfRange is returned when the speed of a segment is negative or above the max speed
+	ErrSpeedOutOfRange = errors.New("speed is out of range")
+)
+
+// idleSpeed is the speed in km/h at or below which a segment is idle
+const idleSpeed = 10
+
+const (
+	// tariff bands of segments
+	bandIdle     = "idle"
+	bandMidnight = "midnight"
+	bandNormal   = "normal"
+)
+
 // Segment is made of two consecutive positions of the same ride
 type Segment struct {
 	rideID     int
+	from, to   Position
 	speed      float64
 	distance   float64
 	duration   time.Duration
@@ -17,26 +35,29 @@ type Segment struct {
 
 // NewSegment creates a Segment out of two Positions
 // the maxSpeed is dismiss the outliers
-func NewSegment(p1, p2 Position, maxSpeed float64) (Segment, error) {
+// the distance is the model to measure the distance between the positions, nil means haversine
+func NewSegment(p1, p2 Position, maxSpeed float64, distance DistanceFunc) (Segment, error) {
 	if p1.RideID != p2.RideID {
-		return Segment{}, errors.New("ride is not the same")
+		return Segment{}, ErrRideMismatch
 	}
 	startedAt := p1.Timestamp
 	finishedAt := p2.Timestamp
 
-	distance := p2.Distance(p1)
+	length := p2.Distance(p1, distance)
 
 	duration := finishedAt.Sub(startedAt)
-	speed := distance / duration.Hours()
+	speed := length / duration.Hours()
 
 	if speed < 0 || speed > maxSpeed {
-		return Segment{}, errors.New("speed is out of range")
+		return Segment{}, ErrSpeedOutOfRange
 	}
 
 	return Segment{
 		rideID:     p1.RideID,
+		from:       p1,
+		to:         p2,
 		speed:      speed,
-		distance:   distance,
+		distance:   length,
 		duration:   duration,
 		startedAt:  p1.Timestamp,
 		finishedAt: p2.Timestamp,
@@ -47,12 +68,29 @@ func NewSegment(p1, p2 Position, maxSpeed float64) (Segment, error) {
 // it assumes that segment is collected in short duration of time
 // so it does not break the segment into two period of midnight hours and normal hours
 func (s Segment) Fare() Price {
-	switch {
-	case s.speed <= 10:
+	switch s.band() {
+	case bandIdle:
 		return Price(s.duration.Minutes() / 60 * fareIdlePerHour)
-	case s.startedAt.Hour() >= 0 && s.finishedAt.Hour() <= 5:
+	case bandMidnight:
 		return Price(s.distance * fareMovingMidnight)
 	default:
 		return Price(s.distance * fareMovingNormal)
 	}
 }
+
+// band returns the tariff band of the segment
+func (s Segment) band() string {
+	switch {
+	case s.idle():
+		return bandIdle
+	case s.startedAt.Hour() >= 0 && s.finishedAt.Hour() <= 5:
+		return bandMidnight
+	default:
+		return bandNormal
+	}
+}
+
+// idle tells if the segment is idle, i.e. the ride is stopped or moving too slow
+func (s Segment) idle() bool {
+	return s.speed <= idleSpeed
+}
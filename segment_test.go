@@ -62,7 +62,7 @@ func TestNewSegment(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			test.check(NewSegment(test.p1, test.p2, test.maxSpeed))
+			test.check(NewSegment(test.p1, test.p2, test.maxSpeed, nil))
 		})
 	}
 }
@@ -126,7 +126,7 @@ func BenchmarkNewSegment(b *testing.B) {
 	}
 
 	for n := 0; n < b.N; n++ {
-		_, _ = NewSegment(p11, p12, 100)
+		_, _ = NewSegment(p11, p12, 100, nil)
 	}
 }
 
@@ -142,3 +142,12 @@ func BenchmarkSegment_Fare(b *testing.B) {
 		_ = segment.Fare()
 	}
 }
+
+func TestSegment_band(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2020, time.January, 1, hour, 0, 0, 0, time.Local)
+	}
+	assert.Equal(t, bandIdle, Segment{speed: 10}.band())
+	assert.Equal(t, bandMidnight, Segment{speed: 20, startedAt: at(1), finishedAt: at(2)}.band())
+	assert.Equal(t, bandNormal, Segment{speed: 20, startedAt: at(12), finishedAt: at(13)}.band())
+}
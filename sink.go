@@ -0,0 +1,128 @@
+package fare
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// defaultOutputColumns are the columns of the CSV output if no columns are selected
+var defaultOutputColumns = []string{"id_ride", "fare"}
+
+// outputColumns formats the columns of the CSV output by their names
+// times are RFC 3339 in UTC, distances are in kilometers, durations in seconds and speeds in km/h
+var outputColumns = map[string]func(f rideFare) string{
+	"id_ride":     func(f rideFare) string { return f.id() },
+	"fare":        func(f rideFare) string { return formatFloat(float64(f.fare), 2) },
+	"started_at":  func(f rideFare) string { return formatTime(f.stats.start.Timestamp) },
+	"finished_at": func(f rideFare) string { return formatTime(f.stats.end.Timestamp) },
+	"start_lat":   func(f rideFare) string { return formatFloat(f.stats.start.Lat, 6) },
+	"start_lng":   func(f rideFare) string { return formatFloat(f.stats.start.Long, 6) },
+	"end_lat":     func(f rideFare) string { return formatFloat(f.stats.end.Lat, 6) },
+	"end_lng":     func(f rideFare) string { return formatFloat(f.stats.end.Long, 6) },
+	"distance":    func(f rideFare) string { return formatFloat(f.stats.distance, 3) },
+	"moving":      func(f rideFare) string { return formatFloat(f.stats.moving.Seconds(), 0) },
+	"idle":        func(f rideFare) string { return formatFloat(f.stats.idle.Seconds(), 0) },
+	"avg_speed":   func(f rideFare) string { return formatFloat(f.stats.avgSpeed(), 2) },
+	"max_speed":   func(f rideFare) string { return formatFloat(f.stats.maxSpeed, 2) },
+	"segments":    func(f rideFare) string { return strconv.Itoa(f.stats.segments) },
+}
+
+// validOutputColumns checks if the columns can be written
+func validOutputColumns(columns []string) error {
+	for _, column := range columns {
+		if _, ok := outputColumns[column]; !ok {
+			return fmt.Errorf("unknown output column %q", column)
+		}
+	}
+	return nil
+}
+
+// extraValues maps the names of the extra columns to their values in a fare, nil if there are no extra columns
+func extraValues(names []string, f rideFare) map[string]string {
+	if len(names) == 0 {
+		return nil
+	}
+	values := make(map[string]string, len(names))
+	for i, name := range names {
+		values[name] = ""
+		if i < len(f.extra) {
+			values[name] = f.extra[i]
+		}
+	}
+	return values
+}
+
+// formatFloat formats a float with the given number of decimals
+func formatFloat(f float64, decimals int) string {
+	return strconv.FormatFloat(f, 'f', decimals, 64)
+}
+
+// formatTime formats a time as RFC 3339 in UTC
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// csvSink writes the fare of rides as csv records of the selected columns,
+// by default of the form id_ride, fare_estimate, followed by the values of the extra columns
+type csvSink struct {
+	writer  *csv.Writer
+	columns []func(f rideFare) string
+	// extra is the number of the extra columns
+	extra int
+}
+
+// newCSVSink creates a csvSink of the columns and the extra columns, nil columns are the default columns
+// the columns should be valid
+func newCSVSink(w io.Writer, columns, extra []string) *csvSink {
+	if columns == nil {
+		columns = defaultOutputColumns
+	}
+	formats := make([]func(f rideFare) string, len(columns))
+	for i, column := range columns {
+		formats[i] = outputColumns[column]
+	}
+	return &csvSink{writer: csv.NewWriter(w), columns: formats, extra: len(extra)}
+}
+
+func (s *csvSink) write(f rideFare) error {
+	line := make(Line, len(s.columns)+s.extra)
+	for i, column := range s.columns {
+		line[i] = column(f)
+	}
+	copy(line[len(s.columns):], f.extra)
+	return s.writer.Write(line)
+}
+
+func (s *csvSink) flush() error {
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+// csvQualitySink writes the quality report of rides as csv records of the form
+// id_ride, parsed, rejected_parse, rejected_outlier, used, reasons
+type csvQualitySink struct {
+	writer *csv.Writer
+}
+
+// newCSVQualitySink creates a csvQualitySink
+func newCSVQualitySink(w io.Writer) *csvQualitySink {
+	return &csvQualitySink{writer: csv.NewWriter(w)}
+}
+
+func (s *csvQualitySink) write(f rideFare) error {
+	if f.quality == nil {
+		return nil
+	}
+	return s.writer.Write(f.quality.record(f.rideId))
+}
+
+func (s *csvQualitySink) flush() error {
+	s.writer.Flush()
+	return s.writer.Error()
+}
@@ -0,0 +1,110 @@
+package fare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/cubny/fare/internal/extsort"
+)
+
+// defaultSortChunkSize is the number of lines which are sorted in memory before they are spilled to disk
+const defaultSortChunkSize = 100000
+
+// sortedColumns are the columns of a sorted line which are added before the line,
+// its line number, the reason why it is malformed if it is, and the sort keys of its ride id and timestamp
+const sortedColumns = 4
+
+// sortLines sorts the lines of the source by ride id and timestamp with an external merge sort
+// the lines are sorted by the keys of sortKeys, which are built once for each line, and are stored
+// along with them, see sortedColumns. the returned iterator must be closed to remove the temporary files
+func (e *estimator) sortLines(ctx context.Context, in source) (*extsort.Iterator, error) {
+	chunkSize := e.conf.SortChunkSize
+	if chunkSize == 0 {
+		chunkSize = defaultSortChunkSize
+	}
+	less := func(a, b []string) bool {
+		if a[2] != b[2] {
+			return a[2] < b[2]
+		}
+		return a[3] < b[3]
+	}
+	sorter, err := extsort.New(less, chunkSize, e.conf.TempDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			sorter.Close()
+			return nil, err
+		}
+		rec, err := in.read()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			malformed := ""
+			if rec.malformed != nil {
+				malformed = rec.malformed.Error()
+			}
+			id, timestamp := e.sortKeys(rec.line)
+			err = sorter.Add(append(Line{strconv.Itoa(rec.number), malformed, id, timestamp}, rec.line...))
+		}
+		if err != nil {
+			sorter.Close()
+			return nil, err
+		}
+	}
+
+	it, err := sorter.Sort()
+	if err != nil {
+		sorter.Close()
+		return nil, err
+	}
+	return it, nil
+}
+
+// streamFromSorted returns pipeline.generateFunc that reads one line at a time from the sorted lines
+func (e *estimator) streamFromSorted(it *extsort.Iterator) func() (record, bool, error) {
+	return func() (record, bool, error) {
+		fields, err := it.Next()
+		if err != nil {
+			return record{}, false, err
+		}
+		number, err := strconv.Atoi(fields[0])
+		rec := record{number: number, line: fields[sortedColumns:]}
+		if fields[1] != "" {
+			rec.malformed = errors.New(fields[1])
+		}
+		return rec, true, err
+	}
+}
+
+// sortKeys returns the keys of the ride id and the timestamp of a line, which order the lines by ride id
+// and then by timestamp when they are compared as strings. The ids and timestamps which can be parsed are ordered
+// by their values after the ones which can't, which are ordered as strings. The ids of the same value are ordered
+// by their strings, so the lines of ids like 01 and 1, which are different rides, are not interleaved
+func (e *estimator) sortKeys(line Line) (id, timestamp string) {
+	var rawID, rawTimestamp string
+	if len(line) > 3 {
+		rawID, rawTimestamp = line[0], line[3]
+	}
+
+	id = "0" + rawID
+	if n, err := strconv.ParseInt(rawID, 10, 64); err == nil {
+		id = "1" + orderedInt(n) + rawID
+	}
+	timestamp = "0" + rawTimestamp
+	if t, err := ParseTimestamp(rawTimestamp, e.conf.TimestampFormat); err == nil {
+		timestamp = "1" + orderedInt(t.Unix()) + fmt.Sprintf("%08x", t.Nanosecond())
+	}
+	return id, timestamp
+}
+
+// orderedInt formats an integer as a string of fixed length, the strings of the integers are in their order
+func orderedInt(n int64) string {
+	return fmt.Sprintf("%016x", uint64(n)^1<<63)
+}
@@ -0,0 +1,49 @@
+package fare
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimator_sortLines(t *testing.T) {
+	data := "10,1,1,1405594966\n2,1,1,1405594957\nb,1,1,1405594957\n10,1,1,1405594957\na,1,1,1405594957\n2,1,1,x\n" +
+		"1,1,1,1405594966\n01,1,1,1405594957\n1,1,1,1405594957\n"
+
+	e, err := NewEstimator(strings.NewReader(data), ioutil.Discard, &Config{MaxSpeed: 100, Concurrency: 1, Unsorted: true})
+	assert.Nil(t, err)
+	// a chunk of two lines makes the sorted chunks be merged
+	e.conf.SortChunkSize = 2
+	it, err := e.sortLines(context.TODO(), newCSVReader(strings.NewReader(data), Schema{}))
+	assert.Nil(t, err)
+	defer it.Close()
+
+	var lines []string
+	next := e.streamFromSorted(it)
+	for {
+		rec, _, err := next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		lines = append(lines, strings.Join(rec.line, ","))
+	}
+
+	// the ids and timestamps which can't be parsed are before the ones which can,
+	// the ids of the same value are not interleaved
+	assert.Equal(t, []string{
+		"a,1,1,1405594957",
+		"b,1,1,1405594957",
+		"01,1,1,1405594957",
+		"1,1,1,1405594957",
+		"1,1,1,1405594966",
+		"2,1,1,x",
+		"2,1,1,1405594957",
+		"10,1,1,1405594957",
+		"10,1,1,1405594966",
+	}, lines)
+}
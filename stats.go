@@ -0,0 +1,48 @@
+package fare
+
+import "time"
+
+// stats holds the statistics of a trip, they are collected from its segments
+type stats struct {
+	// start and end are the first and the last positions of the trip
+	start, end Position
+	// distance is the total distance in kilometers
+	distance float64
+	// moving and idle are the durations of the moving and the idle segments
+	moving, idle time.Duration
+	// maxSpeed is the speed of the fastest segment in km/h
+	maxSpeed float64
+	// segments is the number of segments which are used to estimate the fare
+	segments int
+}
+
+// add adds a segment of the trip to the statistics, the segments are added in order
+func (s *stats) add(seg Segment) {
+	if s.segments == 0 {
+		s.start = seg.from
+	}
+	s.end = seg.to
+	s.distance += seg.distance
+	if seg.idle() {
+		s.idle += seg.duration
+	} else {
+		s.moving += seg.duration
+	}
+	if seg.speed > s.maxSpeed {
+		s.maxSpeed = seg.speed
+	}
+	s.segments++
+}
+
+// duration returns the duration of the trip
+func (s stats) duration() time.Duration {
+	return s.moving + s.idle
+}
+
+// avgSpeed returns the average speed of the trip in km/h, which is 0 for a trip without duration
+func (s stats) avgSpeed() float64 {
+	if s.duration() <= 0 {
+		return 0
+	}
+	return s.distance / s.duration().Hours()
+}
@@ -0,0 +1,36 @@
+package fare
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats_add(t *testing.T) {
+	at := func(seconds int64, lat, lng float64) Position {
+		return Position{RideID: 1, Lat: lat, Long: lng, Timestamp: time.Unix(1405594957+seconds, 0)}
+	}
+	p1, p2, p3 := at(0, 37.966660, 23.728308), at(60, 37.966660, 23.728308), at(120, 37.976660, 23.728308)
+
+	var s stats
+	for _, seg := range []Segment{
+		{from: p1, to: p2, speed: 0, distance: 0, duration: time.Minute},
+		{from: p2, to: p3, speed: 66.7, distance: 1.112, duration: time.Minute},
+	} {
+		s.add(seg)
+	}
+
+	assert.Equal(t, p1, s.start)
+	assert.Equal(t, p3, s.end)
+	assert.Equal(t, 2, s.segments)
+	assert.Equal(t, time.Minute, s.idle)
+	assert.Equal(t, time.Minute, s.moving)
+	assert.Equal(t, 66.7, s.maxSpeed)
+	assert.InDelta(t, 33.36, s.avgSpeed(), 0.001)
+}
+
+func TestStats_avgSpeed(t *testing.T) {
+	assert.Equal(t, float64(0), stats{distance: 1}.avgSpeed())
+	assert.Equal(t, float64(30), stats{distance: 1, moving: time.Minute, idle: time.Minute}.avgSpeed())
+}
@@ -0,0 +1,74 @@
+package fare
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimestampFormat is the format of the timestamps of the input
+type TimestampFormat int
+
+const (
+	// TimestampAuto detects the format of each timestamp, RFC 3339 for non numeric values
+	// and seconds, milliseconds or microseconds by the number of digits of epoch times
+	TimestampAuto TimestampFormat = iota
+	// TimestampSeconds is epoch time in seconds, e.g. 1405594957
+	TimestampSeconds
+	// TimestampMillis is epoch time in milliseconds, e.g. 1405594957123
+	TimestampMillis
+	// TimestampMicros is epoch time in microseconds, e.g. 1405594957123456
+	TimestampMicros
+	// TimestampRFC3339 is a RFC 3339 date time with optional fractional seconds, e.g. 2014-07-17T11:02:37.123+03:00
+	TimestampRFC3339
+)
+
+var errUnknownTimestampFormat = errors.New("unknown timestamp format")
+
+// ParseTimestamp parses a raw timestamp of the given format
+func ParseTimestamp(raw string, format TimestampFormat) (time.Time, error) {
+	if format == TimestampAuto {
+		format = detectTimestampFormat(raw)
+	}
+
+	if format == TimestampRFC3339 {
+		return time.Parse(time.RFC3339Nano, raw)
+	}
+
+	ti, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	switch format {
+	case TimestampSeconds:
+		return time.Unix(ti, 0), nil
+	case TimestampMillis:
+		return time.Unix(ti/1e3, ti%1e3*1e6), nil
+	case TimestampMicros:
+		return time.Unix(ti/1e6, ti%1e6*1e3), nil
+	default:
+		return time.Time{}, errUnknownTimestampFormat
+	}
+}
+
+// detectTimestampFormat guesses the format of a raw timestamp
+// epoch times are told apart by the number of digits, which holds for the dates between 2001 and 2286
+func detectTimestampFormat(raw string) TimestampFormat {
+	digits := strings.TrimPrefix(raw, "-")
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return TimestampRFC3339
+		}
+	}
+
+	switch {
+	case len(digits) <= 10:
+		return TimestampSeconds
+	case len(digits) <= 13:
+		return TimestampMillis
+	default:
+		return TimestampMicros
+	}
+}
@@ -0,0 +1,105 @@
+package fare
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		format    TimestampFormat
+		timestamp time.Time
+		hasError  bool
+	}{
+		{
+			name:      "seconds",
+			raw:       "1405594957",
+			format:    TimestampSeconds,
+			timestamp: time.Unix(1405594957, 0),
+		},
+		{
+			name:      "milliseconds",
+			raw:       "1405594957123",
+			format:    TimestampMillis,
+			timestamp: time.Unix(1405594957, 123000000),
+		},
+		{
+			name:      "microseconds",
+			raw:       "1405594957123456",
+			format:    TimestampMicros,
+			timestamp: time.Unix(1405594957, 123456000),
+		},
+		{
+			name:      "rfc3339 with offset",
+			raw:       "2014-07-17T14:02:37.5+03:00",
+			format:    TimestampRFC3339,
+			timestamp: time.Unix(1405594957, 500000000),
+		},
+		{
+			name:      "auto detects seconds",
+			raw:       "1405594957",
+			format:    TimestampAuto,
+			timestamp: time.Unix(1405594957, 0),
+		},
+		{
+			name:      "auto detects milliseconds",
+			raw:       "1405594957123",
+			format:    TimestampAuto,
+			timestamp: time.Unix(1405594957, 123000000),
+		},
+		{
+			name:      "auto detects microseconds",
+			raw:       "1405594957123456",
+			format:    TimestampAuto,
+			timestamp: time.Unix(1405594957, 123456000),
+		},
+		{
+			name:      "auto detects rfc3339",
+			raw:       "2014-07-17T11:02:37Z",
+			format:    TimestampAuto,
+			timestamp: time.Unix(1405594957, 0),
+		},
+		{
+			name:     "malformed epoch - error",
+			raw:      "14055949a7",
+			format:   TimestampSeconds,
+			hasError: true,
+		},
+		{
+			name:     "malformed rfc3339 - error",
+			raw:      "2014-07-17 11:02:37",
+			format:   TimestampAuto,
+			hasError: true,
+		},
+		{
+			name:     "unknown format - error",
+			raw:      "1405594957",
+			format:   TimestampRFC3339 + 1,
+			hasError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			timestamp, err := ParseTimestamp(test.raw, test.format)
+			assert.Equal(t, test.hasError, err != nil)
+			if !test.hasError {
+				assert.True(t, test.timestamp.Equal(timestamp), "expected %s, got %s", test.timestamp, timestamp)
+			}
+		})
+	}
+}
+
+func TestNewSegment_subSecondDuration(t *testing.T) {
+	p1, err := NewPosition("1", "37.966660", "23.728308", "1405594957250")
+	assert.Nil(t, err)
+	p2, err := NewPosition("1", "37.966627", "23.728263", "2014-07-17T11:02:38.75Z")
+	assert.Nil(t, err)
+
+	segment, err := NewSegment(p1, p2, 100, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1500*time.Millisecond, segment.duration)
+}
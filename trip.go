@@ -0,0 +1,80 @@
+package fare
+
+const (
+	// defaultStationaryRadius is the radius in kilometers in which a ride is considered stationary
+	defaultStationaryRadius = 0.05
+	// reasonNotInTrip is the rejection reason of positions which are dropped by splitting a ride into trips
+	reasonNotInTrip = "not in a trip"
+)
+
+// splitTrips splits the positions of a ride into trips, which are priced separately
+// the ride is split between two positions which are at least TripGap apart in time, and
+// where it stays within the StationaryRadius for at least TripStationary. Such a stationary period is not part of
+// any trip, its first position ends the previous trip and its last position starts the next one.
+// a stationary period at the end of the ride is cut off too.
+// pieces with less than two positions are not trips and they are dropped, unless no trip is left
+func (r *ride) splitTrips(positions []Position) [][]Position {
+	gap := r.conf.TripGap
+	stationary := r.conf.TripStationary
+	radius := r.conf.StationaryRadius
+	if radius == 0 {
+		radius = defaultStationaryRadius
+	}
+	if gap == 0 && stationary == 0 {
+		return [][]Position{positions}
+	}
+
+	var trips [][]Position
+	// start is the first position of the current trip and
+	// anchor is the first position of the current stationary period
+	start, anchor := 0, 0
+	split := func(end, next int) {
+		trips = append(trips, positions[start:end+1])
+		start, anchor = next, next
+	}
+	stationaryUntil := func(last int) bool {
+		return stationary > 0 && positions[last].Timestamp.Sub(positions[anchor].Timestamp) >= stationary
+	}
+
+	for i := 1; i < len(positions); i++ {
+		if gap > 0 && positions[i].Timestamp.Sub(positions[i-1].Timestamp) >= gap {
+			split(i-1, i)
+			continue
+		}
+		if stationary == 0 || positions[i].Distance(positions[anchor], r.conf.Distance) <= radius {
+			continue
+		}
+		if stationaryUntil(i - 1) {
+			split(anchor, i-1)
+		}
+		anchor = i
+	}
+	if len(positions) > 0 {
+		end := len(positions) - 1
+		if stationaryUntil(end) {
+			end = anchor
+		}
+		trips = append(trips, positions[start:end+1])
+	}
+
+	kept := trips[:0]
+	used := 0
+	for _, trip := range trips {
+		if len(trip) > 1 {
+			kept = append(kept, trip)
+			used += len(trip)
+		}
+	}
+	if len(kept) == 0 {
+		return [][]Position{positions}
+	}
+
+	if dropped := len(positions) - used; dropped > 0 {
+		r.mu.Lock()
+		r.quality.rejectedOutlier += dropped
+		r.quality.reject(reasonNotInTrip, dropped)
+		r.mu.Unlock()
+	}
+
+	return kept
+}
@@ -0,0 +1,99 @@
+package fare
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRide_splitTrips(t *testing.T) {
+	start := time.Unix(1405594957, 0)
+	// at returns a position with given offset in seconds and about 100 meters per step to the north
+	at := func(seconds int, steps int) Position {
+		return Position{
+			RideID:    1,
+			Lat:       37.966660 + float64(steps)*0.0009,
+			Long:      23.728308,
+			Timestamp: start.Add(time.Duration(seconds) * time.Second),
+		}
+	}
+	moving := []Position{at(0, 0), at(10, 1), at(20, 2), at(30, 3)}
+	parked := []Position{at(30, 3), at(630, 3), at(1230, 3), at(1830, 3)}
+	movingAgain := []Position{at(1840, 4), at(1850, 5), at(1860, 6)}
+	gapped := []Position{at(0, 0), at(10, 1), at(7210, 2), at(7220, 3)}
+
+	tests := []struct {
+		name      string
+		conf      *Config
+		positions []Position
+		trips     int
+		lengths   []int
+		dropped   int
+	}{
+		{
+			name:      "splitting is disabled",
+			conf:      &Config{},
+			positions: append(append(moving, parked[1:]...), movingAgain...),
+			trips:     1,
+			lengths:   []int{10},
+		},
+		{
+			name:      "split on time gap",
+			conf:      &Config{TripGap: time.Hour},
+			positions: gapped,
+			trips:     2,
+			lengths:   []int{2, 2},
+		},
+		{
+			name:      "split on stationary period",
+			conf:      &Config{TripStationary: 15 * time.Minute},
+			positions: append(append(moving, parked[1:]...), movingAgain...),
+			trips:     2,
+			lengths:   []int{4, 4},
+			dropped:   2,
+		},
+		{
+			name:      "short stationary period is not split",
+			conf:      &Config{TripStationary: time.Hour},
+			positions: append(append(moving, parked[1:]...), movingAgain...),
+			trips:     1,
+			lengths:   []int{10},
+		},
+		{
+			name:      "stationary period at the end is cut off",
+			conf:      &Config{TripStationary: 15 * time.Minute},
+			positions: append(moving, parked[1:]...),
+			trips:     1,
+			lengths:   []int{4},
+			dropped:   3,
+		},
+		{
+			name:      "single position pieces are dropped",
+			conf:      &Config{TripGap: time.Hour},
+			positions: append(append([]Position{}, gapped...), at(14420, 4)),
+			trips:     2,
+			lengths:   []int{2, 2},
+			dropped:   1,
+		},
+		{
+			name:      "no trip is left - not split",
+			conf:      &Config{TripGap: time.Hour},
+			positions: []Position{at(0, 0), at(7200, 1)},
+			trips:     1,
+			lengths:   []int{2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &ride{conf: test.conf}
+			trips := r.splitTrips(test.positions)
+			assert.Equal(t, test.trips, len(trips))
+			for i, trip := range trips {
+				assert.Equal(t, test.lengths[i], len(trip))
+			}
+			assert.Equal(t, test.dropped, r.quality.rejectedOutlier)
+			assert.Equal(t, test.dropped, r.quality.reasons[reasonNotInTrip])
+		})
+	}
+}